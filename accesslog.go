@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accessLog is -access-log: a line per proxied request, kept for as long
+// as the file is, unlike the in-memory history. Nil when not set.
+var accessLog *accessLogger
+
+type accessLogger struct {
+	mu      sync.Mutex
+	path    string // "-" is stdout
+	format  string // "clf" or "json"
+	maxSize int64  // rotate when the file would grow past this; 0 never
+	keep    int    // rotated files kept: path.1 (newest) to path.N
+	file    *os.File
+	w       *bufio.Writer
+	size    int64
+	failing bool // an error was logged; the next ones aren't until a write works
+}
+
+func openAccessLog(path, format string, maxSize int64, keep int) (*accessLogger, error) {
+	if format != "clf" && format != "json" {
+		return nil, fmt.Errorf("unknown format %q (want clf or json)", format)
+	}
+	l := &accessLogger{path: path, format: format, maxSize: maxSize, keep: keep}
+	if path == "-" {
+		l.w = bufio.NewWriter(os.Stdout)
+	} else if err := l.open(); err != nil {
+		return nil, err
+	}
+	go func() {
+		for range time.Tick(time.Second) { // so tail -f keeps up
+			l.flush()
+		}
+	}()
+	return l, nil
+}
+
+func (l *accessLogger) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.w, l.size = f, bufio.NewWriter(f), st.Size()
+	return nil
+}
+
+// write logs e. Errors are reported, once, but never stop the proxy.
+func (l *accessLogger) write(e CombinedLog) {
+	line := l.line(e)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil && l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			l.fail(err)
+		}
+	}
+	if l.w == nil { // reopening after a rotation failed
+		if err := l.open(); err != nil {
+			l.fail(err)
+			return
+		}
+	}
+	if _, err := l.w.WriteString(line); err != nil {
+		l.fail(err)
+		l.w.Reset(l.writer()) // drop what couldn't be written
+		return
+	}
+	l.size += int64(len(line))
+	l.failing = false
+}
+
+func (l *accessLogger) writer() *os.File {
+	if l.file == nil {
+		return os.Stdout
+	}
+	return l.file
+}
+
+func (l *accessLogger) fail(err error) {
+	if !l.failing {
+		log.Printf("-access-log: %v", err)
+	}
+	l.failing = true
+}
+
+// rotate renames path to path.1, path.1 to path.2 and so on, dropping the
+// oldest beyond keep, and starts a new file. Callers hold l.mu.
+func (l *accessLogger) rotate() error {
+	l.w.Flush()
+	l.file.Close()
+	l.file, l.w = nil, nil
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.keep))
+	for n := l.keep - 1; n >= 1; n-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, n), fmt.Sprintf("%s.%d", l.path, n+1))
+	}
+	if l.keep > 0 {
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// flush writes out buffered lines: every second, and on shutdown.
+func (l *accessLogger) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.w != nil {
+		if err := l.w.Flush(); err != nil {
+			l.fail(err)
+			l.w.Reset(l.writer())
+		}
+	}
+}
+
+func (l *accessLogger) line(e CombinedLog) string {
+	started := time.UnixMilli(e.EndTime)
+	if e.StartTime > 0 {
+		started = time.UnixMilli(e.StartTime)
+	}
+	path := e.Path
+	if e.OriginalPath != "" {
+		path = e.OriginalPath // what the client asked for
+	}
+	if e.QueryString != "" {
+		path += "?" + e.QueryString
+	}
+	latency, _ := strconv.ParseFloat(strings.TrimSuffix(e.Latency, "ms"), 64)
+	if l.format == "json" {
+		fields := map[string]any{
+			"time": started.Format(time.RFC3339Nano), "client": e.Client, "method": e.Method,
+			"path": path, "status": e.Status, "req_bytes": e.ReqBytes, "resp_bytes": e.RespBytes,
+			"latency_ms": latency, "seq": e.Seq,
+		}
+		if e.Proxy != "" {
+			fields["proxy"] = e.Proxy
+		}
+		if e.Tag != "" {
+			fields["tag"] = e.Tag
+		}
+		data, _ := json.Marshal(fields)
+		return string(data) + "\n"
+	}
+	// Common Log Format, with the latency appended.
+	host, _, err := net.SplitHostPort(e.Client)
+	if err != nil {
+		host = e.Client
+	}
+	if host == "" {
+		host = "-"
+	}
+	proto := "HTTP/1.1"
+	if first, _, _ := strings.Cut(e.ReqHeaders, "\n"); strings.Contains(first, " HTTP/") {
+		proto = strings.TrimSpace(first[strings.LastIndex(first, " ")+1:])
+	}
+	size := "-"
+	if e.RespBytes > 0 {
+		size = strconv.FormatInt(e.RespBytes, 10)
+	}
+	return fmt.Sprintf("%s - - [%s] %q %d %s %.2fms\n", host, started.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method+" "+path+" "+proto, e.Status, size, latency)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// apiCORSOrigins (-api-cors-origin) lists the browser origins allowed to call
+// the inspector's own routes, e.g. a dashboard on another dev server. "*"
+// allows any origin. Empty means no CORS headers and any /ws origin.
+var apiCORSOrigins []string
+
+// parseOrigins splits comma-separated -api-cors-origin values.
+func parseOrigins(values []string) []string {
+	var origins []string
+	for _, v := range values {
+		for _, o := range strings.Split(v, ",") {
+			if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+				origins = append(origins, o)
+			}
+		}
+	}
+	return origins
+}
+
+func apiOriginAllowed(origin string) bool {
+	return slices.Contains(apiCORSOrigins, "*") || slices.Contains(apiCORSOrigins, origin)
+}
+
+// isInspectorRoute reports whether r is for the inspector rather than the
+// proxy. A preflight is judged by the method it asks about: OPTIONS itself
+// only matches the "/" catch-all.
+func isInspectorRoute(mux *http.ServeMux, r *http.Request) bool {
+	if isPreflight(r) {
+		r = r.Clone(r.Context())
+		r.Method = r.Header.Get("Access-Control-Request-Method")
+	}
+	_, pattern := mux.Handler(r)
+	return pattern != "/"
+}
+
+// withAPICORS adds CORS headers for allowed origins to inspector responses
+// and answers their preflights. It runs before withAuth, since browsers
+// send preflights without the Authorization header.
+func withAPICORS(mux *http.ServeMux, next http.Handler) http.Handler {
+	if len(apiCORSOrigins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !apiOriginAllowed(origin) || !isInspectorRoute(mux, r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Expose-Headers", "X-Total-Count, WWW-Authenticate")
+		if isPreflight(r) {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkWSOrigin is the /ws upgrader's origin check. Without -api-cors-origin
+// any origin may connect; with it, only the inspector's own origin, listed
+// origins and non-browser clients (no Origin header).
+func checkWSOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(apiCORSOrigins) == 0 || origin == "" || apiOriginAllowed(origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
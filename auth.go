@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// authToken, when set (-token), is required on every inspector route: the
+// UI, /ws, /history, /export and /api. Proxied traffic is never checked.
+var authToken string
+
+// generateToken returns a random token for "-token auto".
+func generateToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestToken returns the token sent as "Authorization: Bearer ..." or
+// ?token=.
+func requestToken(r *http.Request) string {
+	if t, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(t)
+	}
+	return r.URL.Query().Get("token")
+}
+
+// validToken reports whether t is the -token or a live share token.
+func validToken(t string) bool {
+	if strings.HasPrefix(t, shareTokenPrefix) {
+		_, err := lookupShare(t)
+		return err == nil
+	}
+	return authToken != "" && subtle.ConstantTimeCompare([]byte(t), []byte(authToken)) == 1
+}
+
+// withAuth answers 401 to inspector requests without a valid token. Which
+// requests are the inspector's is up to the mux: everything that isn't the
+// "/" catch-all, which proxies. Share tokens (POST /api/share) are accepted
+// with or without -token, and only for what authorizeShare allows.
+func withAuth(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isInspectorRoute(mux, r) || isUIAsset(mux, r) {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		if token := requestToken(r); strings.HasPrefix(token, shareTokenPrefix) {
+			g, err := lookupShare(token)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if !authorizeShare(mux, r, g) {
+				http.Error(w, "not available to a share link", http.StatusForbidden)
+				return
+			}
+			mux.ServeHTTP(w, withShare(r, g))
+			return
+		}
+		if authToken != "" &&
+			subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(authToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ProxyEye"`)
+			http.Error(w, "missing or invalid ProxyEye token (-token)", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"strconv"
+)
+
+// listenAddr combines a bind flag with a port: bind may be a host (the port
+// comes from port) or a full HOST:PORT.
+func listenAddr(bind, port string) string {
+	if _, _, err := net.SplitHostPort(bind); err == nil {
+		return bind
+	}
+	return net.JoinHostPort(bind, port)
+}
+
+// isLocalHost reports whether host only accepts connections from this
+// machine.
+func isLocalHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// reachableURL is how this machine reaches a listener bound to host: a
+// wildcard bind is reached over loopback.
+func reachableURL(host string, port int) string {
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// displayURL is reachableURL with loopback shown as "localhost".
+func displayURL(host string, port int) string {
+	if host == "" || isLocalHost(host) || net.ParseIP(host).IsUnspecified() {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+func listenerURL(ln net.Listener) string {
+	a := ln.Addr().(*net.TCPAddr)
+	return displayURL(a.IP.String(), a.Port)
+}
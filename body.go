@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// maxBody is the number of body bytes kept for inspection per request.
+// Atomic because PUT /api/config may change it mid-request.
+var maxBody atomic.Int64
+
+// compactBodies strips insignificant whitespace from JSON bodies before
+// they are stored, to keep long sessions small.
+var compactBodies atomic.Bool
+
+// captureChunks records the size and arrival time of each piece of a
+// streamed response body (-capture-chunks).
+var captureChunks bool
+
+// headersOnly (-headers-only) records no bodies at all. They stream
+// through unread unless a hook needs them, and are never stored.
+var headersOnly bool
+
+// noDecode (-no-decode) keeps gzip/deflate request bodies in history as
+// sent instead of storing a decompressed copy.
+var noDecode bool
+
+// maxChunkReads caps the pieces recorded per response.
+const maxChunkReads = 1000
+
+// chunkRead is one piece of a streamed body as the proxy read it. Go's
+// client removes the chunked framing, and chunks that arrive together are
+// read as one, so these are delivery boundaries rather than exact chunks.
+type chunkRead struct {
+	Bytes int     `json:"bytes"`
+	AtMs  float64 `json:"at_ms"` // since the response headers arrived
+}
+
+// bodyCapture keeps the first max bytes written to it and counts the rest,
+// so a streaming body can be previewed without buffering all of it.
+type bodyCapture struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	max   int64
+	total int64
+	teed  bool // the body is captured as it's read, not up front
+
+	start  time.Time // set to record chunk reads
+	chunks []chunkRead
+}
+
+func (c *bodyCapture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if room := c.max - int64(c.buf.Len()); room > 0 {
+		if int64(len(p)) < room {
+			room = int64(len(p))
+		}
+		c.buf.Write(p[:room])
+	}
+	c.total += int64(len(p))
+	if !c.start.IsZero() && len(p) > 0 && len(c.chunks) < maxChunkReads {
+		ms := float64(time.Since(c.start).Microseconds()) / 1000
+		c.chunks = append(c.chunks, chunkRead{Bytes: len(p), AtMs: ms})
+	}
+	return len(p), nil
+}
+
+// snapshot returns the captured preview and whether bytes were dropped.
+func (c *bodyCapture) snapshot() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.String(), c.total > int64(c.buf.Len())
+}
+
+// size is how many bytes went through, kept or not.
+func (c *bodyCapture) size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total
+}
+
+func (c *bodyCapture) chunkReads() []chunkRead {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.chunks
+}
+
+// captureRequestBody arranges for r's body to be recorded. Small bodies with
+// a known length are buffered up front; chunked or large uploads are teed so
+// they keep streaming to the target while only a preview is kept.
+func captureRequestBody(r *http.Request) *bodyCapture {
+	limit := maxBody.Load()
+	c := &bodyCapture{max: limit}
+	if r.Body == nil || r.Body == http.NoBody {
+		return c
+	}
+	if r.ContentLength >= 0 && r.ContentLength <= limit {
+		reqBodyBytes, _ := io.ReadAll(r.Body)
+		c.Write(reqBodyBytes)
+		// Restore the body so the proxy can still send it to the target
+		r.Body = io.NopCloser(bytes.NewBuffer(reqBodyBytes))
+		return c
+	}
+	c.teed = true
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(r.Body, c), r.Body}
+	return c
+}
+
+// fill reads a teed body of r up to the capture limit now, so the preview
+// is complete, or known to be truncated, before the request is forwarded.
+// The bytes read are put back in front of the rest.
+func (c *bodyCapture) fill(r *http.Request) error {
+	if !c.teed {
+		return nil
+	}
+	head, err := io.ReadAll(io.LimitReader(r.Body, c.max+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+	return err
+}
+
+// streamCapture tees a streaming response body into a preview while it is
+// copied to the client, and calls done once when the stream ends.
+type streamCapture struct {
+	io.ReadCloser
+	c    *bodyCapture
+	once sync.Once
+	done func()
+}
+
+func (s *streamCapture) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	s.c.Write(p[:n])
+	if err != nil {
+		s.once.Do(s.done)
+	}
+	return n, err
+}
+
+func (s *streamCapture) Close() error {
+	err := s.ReadCloser.Close()
+	s.once.Do(s.done)
+	return err
+}
+
+// isStreaming reports whether the proxy will flush resp as it arrives
+// (server-sent events or unknown length). Such bodies are captured while
+// they stream instead of being buffered up front.
+func isStreaming(resp *http.Response) bool {
+	return isEventStream(resp) || resp.ContentLength == -1
+}
+
+// isEventStream reports whether resp is server-sent events, a body that
+// never ends and can't be held back.
+func isEventStream(resp *http.Response) bool {
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mt == "text/event-stream"
+}
+
+// compactJSON returns b with JSON whitespace removed, or b unchanged if it
+// isn't a complete JSON document.
+func compactJSON(b string) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(b)); err != nil {
+		return b
+	}
+	return buf.String()
+}
+
+// encodeBody prepares a captured body for storage. Binary bodies would be
+// mangled by JSON, so they are kept base64-encoded.
+func encodeBody(b string) (body, encoding string) {
+	if utf8.ValidString(b) {
+		return b, ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(b)), "base64"
+}
+
+// decodeBody returns the raw bytes of a stored body.
+func decodeBody(body, encoding string) []byte {
+	if encoding == "base64" {
+		if b, err := base64.StdEncoding.DecodeString(body); err == nil {
+			return b
+		}
+	}
+	return []byte(body)
+}
+
+// handleBodyDownload serves a captured body as a file:
+// GET /history/{seq}/body?side=resp|req
+func handleBodyDownload(w http.ResponseWriter, r *http.Request) {
+	seq, err := pathSeq(r)
+	if err != nil {
+		http.Error(w, "invalid seq", http.StatusBadRequest)
+		return
+	}
+	e, ok := historyBySeq(seq)
+	if !ok {
+		http.Error(w, "no such history entry", http.StatusNotFound)
+		return
+	}
+	var body []byte
+	var header http.Header
+	switch r.URL.Query().Get("side") {
+	case "", "resp":
+		body, header = decodeBody(e.RespBody, e.RespBodyEncoding), parseHeaderDump(e.RespHeaders)
+	case "req":
+		body, header = decodeBody(e.ReqBody, e.ReqBodyEncoding), parseHeaderDump(e.ReqHeaders)
+		if e.ReqDecoded != "" {
+			header.Del("Content-Encoding")
+		}
+	default:
+		http.Error(w, "side must be req or resp", http.StatusBadRequest)
+		return
+	}
+
+	ct := header.Get("Content-Type")
+	if ct == "" {
+		ct = http.DetectContentType(body)
+	}
+	side := r.URL.Query().Get("side")
+	if side == "" {
+		side = "resp"
+	}
+	name := fmt.Sprintf("proxyeye-%d-%s", seq, side)
+	if mt, _, err := mime.ParseMediaType(ct); err == nil {
+		if exts, _ := mime.ExtensionsByType(mt); len(exts) > 0 {
+			name += exts[0]
+		}
+	}
+	w.Header().Set("Content-Type", ct)
+	if enc := header.Get("Content-Encoding"); enc != "" {
+		w.Header().Set("Content-Encoding", enc)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Write(body)
+}
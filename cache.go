@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// Response cache for offline demos. In "cache" mode (-cache-mode) the first
+// response per request is stored and served afterwards; "only" mode
+// (-cache-only) never contacts the target.
+var (
+	cacheMu    sync.Mutex
+	cacheState = "off"
+	cache      = map[string]*cachedResponse{}
+)
+
+type cachedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func cacheKey(r *http.Request) string {
+	return requestProxyName(r) + " " + r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery
+}
+
+// serveCache answers r from the cache. On a miss it marks info so
+// storeCache keeps the target's response, or answers 504 with -cache-only.
+func serveCache(w http.ResponseWriter, r *http.Request, info *requestInfo) bool {
+	key := cacheKey(r)
+	cacheMu.Lock()
+	state, c := cacheState, cache[key]
+	cacheMu.Unlock()
+	if state == "off" || info.target != "" {
+		return false // X-ProxyEye-Target requests always reach their backend
+	}
+	if c != nil {
+		info.source = "cache"
+		respondSynthetic(w, r, c.status, c.header.Clone(), c.body)
+		return true
+	}
+	if state == "only" {
+		info.source = "cache"
+		header := http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+		respondSynthetic(w, r, http.StatusGatewayTimeout, header, []byte("ProxyEye cache: no cached response for this request\n"))
+		return true
+	}
+	info.cacheKey = key
+	return false
+}
+
+// storeCache keeps resp for later identical requests. Server errors,
+// server-sent events and bodies over -max-body aren't cached; chunked
+// responses are cached like any other.
+func storeCache(resp *http.Response, info *requestInfo) {
+	if info.cacheKey == "" || resp.StatusCode >= 500 || isEventStream(resp) {
+		return
+	}
+	limit := maxBody.Load()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	if err != nil || int64(len(body)) > limit {
+		return
+	}
+	header := resp.Header.Clone()
+	for _, h := range []string{"Content-Length", "Transfer-Encoding", "Connection"} {
+		header.Del(h)
+	}
+	cacheMu.Lock()
+	cache[info.cacheKey] = &cachedResponse{resp.StatusCode, header, body}
+	cacheMu.Unlock()
+}
+
+// handleCacheAPI shows the cache (GET), switches mode at runtime (POST
+// {"mode": "off"|"cache"|"only"}), e.g. to go offline after a warm-up pass,
+// or clears it (DELETE).
+func handleCacheAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Mode string `json:"mode"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Mode != "off" && req.Mode != "cache" && req.Mode != "only" {
+			http.Error(w, "mode must be off, cache or only", http.StatusBadRequest)
+			return
+		}
+		cacheMu.Lock()
+		cacheState = req.Mode
+		cacheMu.Unlock()
+	case http.MethodDelete:
+		cacheMu.Lock()
+		clear(cache)
+		cacheMu.Unlock()
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cacheMu.Lock()
+	keys := []string{}
+	for k := range cache {
+		keys = append(keys, k)
+	}
+	state := cacheState
+	cacheMu.Unlock()
+	slices.Sort(keys)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"mode": state, "entries": keys})
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// captureRule selects traffic to record. ContentType, when set, is matched
+// against the response Content-Type.
+type captureRule struct {
+	routeMatcher
+	ContentType string `json:"content_type,omitempty"`
+
+	typeRe *regexp.Regexp
+}
+
+var (
+	captureMu    sync.Mutex
+	captureRules []*captureRule // -capture-only; empty = capture everything
+	ignoreRules  []*captureRule // -ignore; always wins over -capture-only
+
+	capturedCount atomic.Int64
+	skippedCount  atomic.Int64
+	ignoredCount  atomic.Int64 // subset of skippedCount matched by -ignore
+	droppedCount  atomic.Int64 // not recorded: the broadcaster's queue was full
+	cliDropped    atomic.Int64 // recorded, but not shown: the CLI's queue was full
+
+	capturePaused atomic.Bool  // POST /api/capture/pause: proxy without recording
+	pausedCount   atomic.Int64 // requests not recorded during the current pause
+)
+
+// parseCaptureRule parses "[METHOD ]PATH[ type=CONTENT-TYPE]".
+func parseCaptureRule(spec string) (*captureRule, error) {
+	rule := &captureRule{}
+	if i := strings.LastIndex(spec, " type="); i >= 0 {
+		rule.ContentType = strings.TrimSpace(spec[i+len(" type="):])
+		spec = spec[:i]
+	}
+	rule.routeMatcher = parseRoute(spec)
+	return rule, rule.compile()
+}
+
+func (c *captureRule) compile() error {
+	if err := c.routeMatcher.compile(); err != nil {
+		return err
+	}
+	if c.ContentType != "" {
+		re, err := regexp.Compile(c.ContentType)
+		if err != nil {
+			return fmt.Errorf("invalid content type pattern %q: %v", c.ContentType, err)
+		}
+		c.typeRe = re
+	}
+	return nil
+}
+
+// captureDecision decides at request time whether r should be recorded. When
+// the matching rule restricts the response content type, typeRe is returned
+// so the check can be finished in captureResponse.
+func captureDecision(r *http.Request) (capture bool, typeRe *regexp.Regexp) {
+	if capturePaused.Load() {
+		pausedCount.Add(1)
+		return false, nil
+	}
+	captureMu.Lock()
+	defer captureMu.Unlock()
+	for _, c := range ignoreRules {
+		if c.matches(r) && c.typeRe == nil {
+			ignoredCount.Add(1)
+			return false, nil
+		}
+	}
+	if len(captureRules) == 0 {
+		return true, nil
+	}
+	for _, c := range captureRules {
+		if c.matches(r) {
+			return true, c.typeRe
+		}
+	}
+	return false, nil
+}
+
+// responseIgnored reports whether an ignore rule with a content type
+// excludes this response.
+func responseIgnored(r *http.Request, contentType string) bool {
+	captureMu.Lock()
+	defer captureMu.Unlock()
+	for _, c := range ignoreRules {
+		if c.typeRe != nil && c.matches(r) && c.typeRe.MatchString(contentType) {
+			ignoredCount.Add(1)
+			return true
+		}
+	}
+	return false
+}
+
+// handleStatsAPI serves /api/stats: capture counters, plus body sizes for
+// the entries still in history.
+func handleStatsAPI(w http.ResponseWriter, r *http.Request) {
+	historyMutex.Lock()
+	entries := append([]CombinedLog(nil), history...)
+	historyMutex.Unlock()
+
+	stats := struct {
+		Captured int64 `json:"captured"`
+		Skipped  int64 `json:"skipped"`
+		Ignored  int64 `json:"ignored"`
+		Dropped  int64 `json:"dropped"`      // lost because capture fell behind
+		CLIDrops int64 `json:"cli_dropped"`  // recorded but not printed
+		Filtered int64 `json:"cli_filtered"` // recorded, but hidden by -cli-filter
+		sizeStats
+	}{capturedCount.Load(), skippedCount.Load(), ignoredCount.Load(), droppedCount.Load(), cliDropped.Load(), cliFiltered.Load(), bodySizeStats(entries)}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// spec renders the rule back into its flag syntax.
+func (c *captureRule) spec() string {
+	s := c.Path
+	if c.Method != "" {
+		s = c.Method + " " + s
+	}
+	if c.ContentType != "" {
+		s += " type=" + c.ContentType
+	}
+	return s
+}
+
+// handleIgnoresAPI lists ignore rules (GET) or adds/removes them at runtime
+// (POST {"add": ["GET ^/healthz$"], "remove": ["^/metrics"]}).
+func handleIgnoresAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Add    []string `json:"add"`
+			Remove []string `json:"remove"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var added []*captureRule
+		for _, spec := range req.Add {
+			rule, err := parseCaptureRule(spec)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			added = append(added, rule)
+		}
+		captureMu.Lock()
+		kept := ignoreRules[:0:0]
+		for _, c := range ignoreRules {
+			removed := false
+			for _, spec := range req.Remove {
+				if rule, err := parseCaptureRule(spec); err == nil && rule.spec() == c.spec() {
+					removed = true
+				}
+			}
+			if !removed {
+				kept = append(kept, c)
+			}
+		}
+		ignoreRules = append(kept, added...)
+		captureMu.Unlock()
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	captureMu.Lock()
+	specs := []string{}
+	for _, c := range ignoreRules {
+		specs = append(specs, c.spec())
+	}
+	captureMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"ignores": specs, "ignored": ignoredCount.Load()})
+}
+
+// setCapturePaused pauses or resumes recording and tells the inspector.
+// It reports whether the state changed.
+func setCapturePaused(paused bool) bool {
+	if capturePaused.Swap(paused) == paused {
+		return false
+	}
+	if paused {
+		pausedCount.Store(0)
+	}
+	broadcastEvent(map[string]any{"type": "capture", "paused": paused})
+	return true
+}
+
+// handleCapturePause pauses or resumes recording
+// (POST /api/capture/{pause|resume}). Traffic keeps flowing while paused.
+func handleCapturePause(w http.ResponseWriter, r *http.Request) {
+	paused := r.PathValue("action") == "pause"
+	if !paused && r.PathValue("action") != "resume" {
+		http.NotFound(w, r)
+		return
+	}
+	out := map[string]any{"paused": paused}
+	if setCapturePaused(paused) && !paused {
+		out["skipped_while_paused"] = pausedCount.Load()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chaosRule injects latency and/or faults into matching proxied requests.
+type chaosRule struct {
+	routeMatcher
+	Delay   string  `json:"delay,omitempty"`   // "2s", or "500ms-2s" for a random delay
+	Percent float64 `json:"percent,omitempty"` // share of matching requests that fail
+	Status  int     `json:"status,omitempty"`  // synthetic status returned on failure
+	Action  string  `json:"action,omitempty"`  // "status" (default), "abort", or a connection action
+	Bytes   int     `json:"bytes,omitempty"`   // body bytes sent before close_after_n_bytes
+
+	delayMin, delayMax time.Duration
+}
+
+type chaosConfig struct {
+	Seed  int64        `json:"seed"`
+	Rules []*chaosRule `json:"rules"`
+}
+
+var (
+	chaosMu    sync.Mutex
+	chaosRules []*chaosRule
+	chaosSeed  int64
+	chaosRand  *rand.Rand
+)
+
+func (c *chaosRule) compile() error {
+	if err := c.routeMatcher.compile(); err != nil {
+		return err
+	}
+	if c.Delay != "" {
+		lo, hi, _ := strings.Cut(c.Delay, "-")
+		min, err := time.ParseDuration(lo)
+		if err != nil {
+			return fmt.Errorf("invalid delay %q: %v", c.Delay, err)
+		}
+		max := min
+		if hi != "" {
+			if max, err = time.ParseDuration(hi); err != nil || max < min {
+				return fmt.Errorf("invalid delay range %q", c.Delay)
+			}
+		}
+		c.delayMin, c.delayMax = min, max
+	}
+	if c.Status != 0 || c.Action != "" {
+		if c.Percent == 0 {
+			c.Percent = 100
+		}
+		if c.Action == "" {
+			c.Action = "status"
+		}
+	}
+	switch c.Action {
+	case "", "abort", "close_after_headers", "garbage_response":
+	case "close_after_n_bytes":
+		if c.Bytes < 0 {
+			return fmt.Errorf("invalid byte count %d", c.Bytes)
+		}
+	case "status":
+		if c.Status < 100 || c.Status > 599 {
+			return fmt.Errorf("invalid fault status %d", c.Status)
+		}
+	default:
+		return fmt.Errorf("unknown fault action %q", c.Action)
+	}
+	if c.Percent < 0 || c.Percent > 100 {
+		return fmt.Errorf("invalid fault percentage %v", c.Percent)
+	}
+	return nil
+}
+
+// parseDelayFlag parses "-delay [METHOD ]PATH=DURATION[-DURATION]".
+func parseDelayFlag(spec string) (*chaosRule, error) {
+	m, val, err := parseRouteSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	m.Path = anchorPlainPath(m.Path)
+	rule := &chaosRule{routeMatcher: m, Delay: val}
+	return rule, rule.compile()
+}
+
+// anchorPlainPath makes a -delay or -fail PATH without regex syntax match
+// that path only, so /api/slow leaves /api/slow-down and /v2/api/slow
+// alone. A dot alone doesn't count as regex syntax. PATH with any other
+// metacharacter, such as ^/api/ or /users/\d+, is used as written.
+func anchorPlainPath(path string) string {
+	if strings.ContainsAny(path, `\+*?()[]{}|^$`) {
+		return path
+	}
+	return "^" + regexp.QuoteMeta(path) + "$"
+}
+
+// parseDelayPathFlag parses "-delay-path [METHOD ]PATH=DURATION[-DURATION]".
+// Unlike -delay, PATH is taken literally: it matches that exact path, or
+// every path starting with it when it ends in "*".
+func parseDelayPathFlag(spec string) (*chaosRule, error) {
+	m, val, err := parseRouteSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	if prefix, ok := strings.CutSuffix(m.Path, "*"); ok {
+		m.Path = "^" + regexp.QuoteMeta(prefix)
+	} else {
+		m.Path = "^" + regexp.QuoteMeta(m.Path) + "$"
+	}
+	rule := &chaosRule{routeMatcher: m, Delay: val}
+	return rule, rule.compile()
+}
+
+// parseFailFlag parses "-fail [METHOD ]PATH=PCT%:STATUS", or an action
+// instead of a status: abort, close_after_headers, close_after_n_bytes:N,
+// garbage_response.
+func parseFailFlag(spec string) (*chaosRule, error) {
+	m, val, err := parseRouteSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	m.Path = anchorPlainPath(m.Path)
+	rule := &chaosRule{routeMatcher: m}
+	pct, action, ok := strings.Cut(val, ":")
+	if !ok {
+		pct, action = "100%", val
+	}
+	if rule.Percent, err = strconv.ParseFloat(strings.TrimSuffix(pct, "%"), 64); err != nil {
+		return nil, fmt.Errorf("invalid fault percentage in %q", spec)
+	}
+	if n, ok := strings.CutPrefix(action, "close_after_n_bytes:"); ok {
+		rule.Action = "close_after_n_bytes"
+		if rule.Bytes, err = strconv.Atoi(n); err != nil {
+			return nil, fmt.Errorf("invalid byte count in %q", spec)
+		}
+	} else if rule.Status, err = strconv.Atoi(action); err != nil {
+		rule.Action = action
+	}
+	return rule, rule.compile()
+}
+
+func setChaos(cfg chaosConfig) {
+	chaosMu.Lock()
+	defer chaosMu.Unlock()
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	chaosSeed = cfg.Seed
+	chaosRand = rand.New(rand.NewSource(cfg.Seed))
+	chaosRules = cfg.Rules
+}
+
+// chaosFor decides the delay and fault (if any) for a request. The fault is
+// the action of the first matching rule whose sampling fires.
+func chaosFor(r *http.Request) (delay time.Duration, fault *chaosRule) {
+	chaosMu.Lock()
+	defer chaosMu.Unlock()
+	for _, c := range chaosRules {
+		if !c.matches(r) {
+			continue
+		}
+		if delay == 0 && c.delayMax > 0 {
+			delay = c.delayMin
+			if span := c.delayMax - c.delayMin; span > 0 {
+				delay += time.Duration(chaosRand.Int63n(int64(span)))
+			}
+		}
+		if fault == nil && c.Action != "" && chaosRand.Float64()*100 < c.Percent {
+			fault = c
+		}
+	}
+	return delay, fault
+}
+
+// applyChaos runs the chaos rules for r. It returns a (possibly wrapped)
+// ResponseWriter and false, or true when it already answered the request.
+func applyChaos(w http.ResponseWriter, r *http.Request, info *requestInfo) (http.ResponseWriter, bool) {
+	delay, fault := chaosFor(r)
+	if delay > 0 {
+		info.injectedDelay = delay
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return w, true
+		}
+	}
+	if fault == nil {
+		return w, false
+	}
+	switch fault.Action {
+	case "abort":
+		info.injectedFault = "abort"
+		return &abortWriter{ResponseWriter: w}, false
+	case "close_after_headers", "close_after_n_bytes", "garbage_response":
+		info.injectedFault = fault.Action
+		m := &mangleWriter{w: w, action: fault.Action, bytes: fault.Bytes, header: http.Header{}}
+		info.finish = m.close
+		return m, false
+	}
+	info.injectedFault = strconv.Itoa(fault.Status)
+	body := fmt.Sprintf("ProxyEye injected fault: %d %s\n", fault.Status, http.StatusText(fault.Status))
+	header := http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+	respondSynthetic(w, r, fault.Status, header, []byte(body))
+	return w, true
+}
+
+// abortWriter lets the headers and part of the first body chunk through and
+// then kills the connection, simulating a backend dying mid-response.
+type abortWriter struct {
+	http.ResponseWriter
+}
+
+func (a *abortWriter) Write(p []byte) (int, error) {
+	a.ResponseWriter.Write(p[:len(p)/2])
+	http.NewResponseController(a.ResponseWriter).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func (a *abortWriter) Unwrap() http.ResponseWriter { return a.ResponseWriter }
+
+func handleChaosAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var cfg chaosConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, c := range cfg.Rules {
+			if err := c.compile(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		setChaos(cfg)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	chaosMu.Lock()
+	defer chaosMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(chaosConfig{Seed: chaosSeed, Rules: chaosRules})
+}
+
+// mangleWriter hijacks the client connection when the response starts
+// and delivers a broken version of it: headers only, the first N body
+// bytes, or bytes that aren't HTTP at all. Body bytes go out as they
+// arrive, so streams keep streaming up to the cut.
+type mangleWriter struct {
+	w       http.ResponseWriter
+	action  string
+	bytes   int
+	header  http.Header
+	conn    net.Conn
+	buf     *bufio.ReadWriter
+	chunked bool // no Content-Length to promise: the body is sent chunked
+	left    int  // body bytes still to send
+	closed  bool
+}
+
+// errChaosClosed is what writes get once the connection was cut, so the
+// proxy stops copying the target's response.
+var errChaosClosed = errors.New("connection closed by chaos rule")
+
+func (m *mangleWriter) Header() http.Header { return m.header }
+
+func (m *mangleWriter) WriteHeader(status int) {
+	if m.conn != nil || m.closed || status < 200 {
+		return
+	}
+	conn, buf, err := http.NewResponseController(m.w).Hijack()
+	if err != nil {
+		// No connection to break (HTTP/2): reset the stream instead.
+		panic(http.ErrAbortHandler)
+	}
+	m.conn, m.buf = conn, buf
+	if m.action == "garbage_response" {
+		buf.WriteString("HTTP/1.1 ??? \x00\x01\r\nnot-a-header\r\n\xff\xfe")
+		garbage := make([]byte, 64)
+		chaosMu.Lock()
+		chaosRand.Read(garbage)
+		chaosMu.Unlock()
+		buf.Write(garbage)
+		m.close()
+		return
+	}
+	// Promise the full body, then hang up early.
+	if m.header.Get("Content-Length") == "" {
+		m.header.Set("Transfer-Encoding", "chunked")
+		m.chunked = true
+	}
+	fmt.Fprintf(buf, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	m.header.Write(buf)
+	buf.WriteString("\r\n")
+	if m.action == "close_after_n_bytes" {
+		m.left = m.bytes
+	}
+	if m.left == 0 {
+		m.close()
+		return
+	}
+	buf.Flush()
+}
+
+func (m *mangleWriter) Write(p []byte) (int, error) {
+	m.WriteHeader(http.StatusOK)
+	if m.closed {
+		return 0, errChaosClosed
+	}
+	n := min(len(p), m.left)
+	if m.chunked {
+		fmt.Fprintf(m.buf, "%x\r\n", n)
+		m.buf.Write(p[:n])
+		m.buf.WriteString("\r\n")
+	} else {
+		m.buf.Write(p[:n])
+	}
+	m.left -= n
+	if m.left == 0 {
+		m.close()
+		return n, errChaosClosed
+	}
+	return n, m.buf.Flush()
+}
+
+func (m *mangleWriter) Flush() {
+	if m.buf != nil && !m.closed {
+		m.buf.Flush()
+	}
+}
+
+// close hangs up. It also runs once the handler returns, for responses
+// shorter than the cut or never started.
+func (m *mangleWriter) close() {
+	if m.conn == nil {
+		m.WriteHeader(http.StatusOK)
+	}
+	if m.closed {
+		return
+	}
+	m.closed = true
+	m.buf.Flush()
+	m.conn.Close()
+}
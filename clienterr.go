@@ -0,0 +1,57 @@
+package main
+
+import "net/http"
+
+// clientErrWriter notes the first write to the client that fails: it hung
+// up mid-response, or the connection broke. ReverseProxy aborts the
+// handler when that happens without logging anything (ErrorLog never sees
+// it), so the writer is where it shows.
+type clientErrWriter struct {
+	http.ResponseWriter
+	info *requestInfo
+}
+
+func (c *clientErrWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	if err != nil && c.info.clientError == "" {
+		c.info.clientError = err.Error()
+	}
+	return n, err
+}
+
+func (c *clientErrWriter) Unwrap() http.ResponseWriter { return c.ResponseWriter }
+
+// lateClientErrors holds client errors, by request ID, for entries not yet
+// in history; publishEntry adds them. Guarded by clientsMu.
+var lateClientErrors = map[uint64]string{}
+
+// reportClientError adds info's client error to its entry once the
+// response is over. Most entries are recorded before their body is sent
+// on, so the entry is in history or on its way there; a stream recorded
+// after the failure got the error from recordEntry already. The change is
+// announced as entry_annotated.
+func reportClientError(info *requestInfo) {
+	if info.clientError == "" || info.requestID == 0 || info.injectedFault != "" || !info.recorded.Load() {
+		return // -fail breaks connections on purpose
+	}
+	clientsMu.Lock()
+	historyMutex.Lock()
+	var e CombinedLog
+	found := false
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].RequestID == info.requestID {
+			found = history[i].ClientError == ""
+			history[i].ClientError = info.clientError
+			e = history[i]
+			break
+		}
+	}
+	if e.Seq == 0 {
+		lateClientErrors[info.requestID] = info.clientError
+	}
+	historyMutex.Unlock()
+	clientsMu.Unlock()
+	if found {
+		broadcastEntryEvent(map[string]any{"type": "entry_annotated", "seq": e.Seq, "client_error": e.ClientError}, &e)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// clientIDs numbers live-feed subscribers, websockets and /stream readers
+// alike, for GET /api/clients.
+var clientIDs atomic.Uint64
+
+// clientStats is what GET /api/clients reports about a subscriber besides
+// its settings. Both wsClient and streamClient embed it.
+type clientStats struct {
+	id        uint64
+	remote    string
+	connected time.Time
+	sent      atomic.Int64 // messages written to the connection
+	lost      atomic.Int64 // messages dropped because it fell behind, in total
+	bytes     atomic.Int64 // size of the messages sent, before compression
+	wire      atomic.Int64 // bytes written to the connection, frames included
+}
+
+func newClientStats(r *http.Request) clientStats {
+	return clientStats{id: clientIDs.Add(1), remote: r.RemoteAddr, connected: time.Now()}
+}
+
+type clientInfo struct {
+	ID         uint64    `json:"id"`
+	Kind       string    `json:"kind"` // "websocket" or "stream"
+	Remote     string    `json:"remote"`
+	Connected  string    `json:"connected"`
+	Encoding   string    `json:"encoding"`       // json, msgpack or ndjson
+	Mode       string    `json:"mode,omitempty"` // websocket: full or summary
+	Legacy     bool      `json:"legacy,omitempty"`
+	Filter     *wsFilter `json:"filter,omitempty"`
+	Share      string    `json:"share,omitempty"`      // share link ID
+	Compressed bool      `json:"compressed,omitempty"` // websocket: permessage-deflate negotiated
+	Sent       int64     `json:"sent"`
+	Dropped    int64     `json:"dropped"`
+	Queued     int       `json:"queued"`
+	Bytes      int64     `json:"bytes"`      // the messages' own size
+	WireBytes  int64     `json:"wire_bytes"` // what went over the network
+}
+
+func (s *clientStats) info(kind string, filter *wsFilter, share *shareGrant, queued int) clientInfo {
+	ci := clientInfo{
+		ID: s.id, Kind: kind, Remote: s.remote, Connected: s.connected.Format(time.RFC3339),
+		Filter: filter, Sent: s.sent.Load(), Dropped: s.lost.Load(), Queued: queued,
+		Bytes: s.bytes.Load(), WireBytes: s.wire.Load(),
+	}
+	if share != nil {
+		ci.Share = share.ID
+	}
+	return ci
+}
+
+// handleClients lists the websocket and /stream subscribers
+// (GET /api/clients), oldest first.
+func handleClients(w http.ResponseWriter, r *http.Request) {
+	list := []clientInfo{}
+	clientsMu.Lock()
+	for c := range clients {
+		ci := c.info("websocket", c.filter, c.share, len(c.send))
+		ci.Encoding, ci.Mode, ci.Legacy, ci.Compressed = "json", "full", c.legacy, c.compressed
+		if c.msgpack {
+			ci.Encoding = "msgpack"
+		}
+		if c.summary {
+			ci.Mode = "summary"
+		}
+		list = append(list, ci)
+	}
+	for s := range streams {
+		ci := s.info("stream", s.filter, s.share, len(s.send))
+		ci.Encoding = "ndjson"
+		list = append(list, ci)
+	}
+	clientsMu.Unlock()
+	slices.SortFunc(list, func(a, b clientInfo) int { return cmp.Compare(a.ID, b.ID) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// handleDisconnectClient force-disconnects one subscriber
+// (DELETE /api/clients/{id}). A websocket gets a 1008 close frame, which
+// stops the inspector from reconnecting; a /stream response just ends.
+func handleDisconnectClient(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid client id", http.StatusBadRequest)
+		return
+	}
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for c := range clients {
+		if c.id == id {
+			msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "disconnected by the ProxyEye owner")
+			c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			c.conn.Close()
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	for s := range streams {
+		if s.id == id {
+			delete(streams, s)
+			close(s.send)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	http.Error(w, "no such client", http.StatusNotFound)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// -cli-filter narrows what the terminal shows, e.g. "status>=400" or
+// "method=POST path~^/api/orders". Conditions, within a flag and across
+// repeated flags, must all hold. History and websocket clients still get
+// every entry.
+var (
+	cliFilters []cliCondition
+	// cliFiltered counts the entries -cli-filter kept off the terminal.
+	cliFiltered atomic.Int64
+	// cliFilterReport is how often the CLI says how many were hidden.
+	cliFilterReport = 10 * time.Second
+)
+
+type cliCondition struct {
+	field string
+	op    string
+	value string
+	num   float64 // status, or latency in ms
+	re    *regexp.Regexp
+}
+
+// cliFilterFields lists the operators each field takes.
+var cliFilterFields = map[string][]string{
+	"status":  {"=", "!=", ">", ">=", "<", "<="},
+	"latency": {">", ">=", "<", "<="},
+	"method":  {"=", "!="},
+	"path":    {"=", "!=", "~", "!~"},
+	"proxy":   {"=", "!=", "~", "!~"},
+	"tag":     {"=", "!=", "~", "!~"},
+}
+
+// parseCLIFilter parses a -cli-filter: conditions separated by spaces.
+func parseCLIFilter(spec string) ([]cliCondition, error) {
+	var conds []cliCondition
+	for _, expr := range strings.Fields(spec) {
+		c, err := parseCLICondition(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", expr, err)
+		}
+		conds = append(conds, c)
+	}
+	if len(conds) == 0 {
+		return nil, fmt.Errorf("empty filter")
+	}
+	return conds, nil
+}
+
+func parseCLICondition(expr string) (cliCondition, error) {
+	end := strings.IndexAny(expr, "=!<>~")
+	if end <= 0 {
+		return cliCondition{}, fmt.Errorf("want FIELD OP VALUE, e.g. status>=400, method=POST, path~^/api, latency>200ms")
+	}
+	c := cliCondition{field: strings.ToLower(expr[:end])}
+	ops, ok := cliFilterFields[c.field]
+	if !ok {
+		return c, fmt.Errorf("unknown field %q (want status, latency, method, path, proxy or tag)", c.field)
+	}
+	rest := expr[end:]
+	for _, op := range []string{">=", "<=", "!=", "!~", "=", "~", ">", "<"} {
+		if strings.HasPrefix(rest, op) {
+			c.op, c.value = op, rest[len(op):]
+			break
+		}
+	}
+	if !slices.Contains(ops, c.op) {
+		return c, fmt.Errorf("%s takes %s", c.field, strings.Join(ops, " "))
+	}
+	if c.value == "" {
+		return c, fmt.Errorf("missing value after %s", c.op)
+	}
+	switch c.field {
+	case "status":
+		n, err := strconv.Atoi(c.value)
+		if err != nil {
+			return c, fmt.Errorf("status must be a number")
+		}
+		c.num = float64(n)
+	case "latency":
+		d, err := time.ParseDuration(c.value)
+		if err != nil {
+			return c, fmt.Errorf("latency must be a duration such as 200ms or 1.5s")
+		}
+		c.num = float64(d) / float64(time.Millisecond)
+	}
+	if c.op == "~" || c.op == "!~" {
+		re, err := regexp.Compile(c.value)
+		if err != nil {
+			return c, fmt.Errorf("invalid regular expression: %v", err)
+		}
+		c.re = re
+	}
+	return c, nil
+}
+
+func (c *cliCondition) matches(e *CombinedLog) bool {
+	var num float64
+	var text string
+	switch c.field {
+	case "status":
+		num = float64(e.Status)
+	case "latency":
+		num, _ = strconv.ParseFloat(strings.TrimSuffix(e.Latency, "ms"), 64)
+	case "method":
+		return strings.EqualFold(e.Method, c.value) == (c.op == "=")
+	case "path":
+		text = clientPath(*e)
+	case "proxy":
+		text = e.Proxy
+	case "tag":
+		text = e.Tag
+	}
+	switch c.op {
+	case "=", "!=":
+		if c.field == "status" {
+			return (num == c.num) == (c.op == "=")
+		}
+		return (text == c.value) == (c.op == "=")
+	case "~":
+		return c.re.MatchString(text)
+	case "!~":
+		return !c.re.MatchString(text)
+	case ">":
+		return num > c.num
+	case ">=":
+		return num >= c.num
+	case "<":
+		return num < c.num
+	}
+	return num <= c.num
+}
+
+// cliShows reports whether e passes every -cli-filter condition.
+func cliShows(e *CombinedLog) bool {
+	for i := range cliFilters {
+		if !cliFilters[i].matches(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterReport prints, every -cli-filter-report, how many entries were
+// hidden since the last report, if any.
+type filterReport struct {
+	last   time.Time
+	hidden int64
+}
+
+func (f *filterReport) line() string {
+	if len(cliFilters) == 0 || cliFilterReport <= 0 || time.Since(f.last) < cliFilterReport {
+		return ""
+	}
+	f.last = time.Now()
+	total := cliFiltered.Load()
+	n := total - f.hidden
+	f.hidden = total
+	if n == 0 {
+		return ""
+	}
+	return colorize("2", fmt.Sprintf("... %d requests hidden by -cli-filter", n))
+}
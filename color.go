@@ -0,0 +1,38 @@
+package main
+
+import "os"
+
+// ansiOut is whether stdout takes ANSI escape sequences: it's a terminal,
+// and on Windows one with virtual terminal processing. Output piped to a
+// file or another program gets no colors and no cursor movement.
+var ansiOut = true
+
+// enableANSI readies f for escape sequences. Windows consoles need them
+// switched on (color_windows.go); elsewhere a terminal takes them as is.
+var enableANSI = func(f *os.File) bool { return true }
+
+func setupANSI() {
+	ansiOut = isTerminal(os.Stdout) && enableANSI(os.Stdout)
+}
+
+// colorScheme is -color-scheme: "methods" colors each method as well as
+// the status, "status" colors only the status.
+var colorScheme = "methods"
+
+var methodColors = map[string]string{
+	"GET":     "32", // Green
+	"POST":    "34", // Blue
+	"PUT":     "33", // Yellow
+	"PATCH":   "33",
+	"DELETE":  "31", // Red
+	"HEAD":    "36", // Cyan
+	"OPTIONS": "36",
+}
+
+// methodColor picks the ANSI color for a method, or "" to leave it plain.
+func methodColor(method string) string {
+	if colorScheme != "methods" {
+		return ""
+	}
+	return methodColors[method]
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+func init() {
+	enableANSI = enableVirtualTerminal
+}
+
+// enableVirtualTerminal turns on escape sequence handling in a Windows
+// console. Consoles older than Windows 10 refuse, and get plain text.
+func enableVirtualTerminal(f *os.File) bool {
+	const enableVirtualTerminalProcessing = 0x0004
+	h := syscall.Handle(f.Fd())
+	var mode uint32
+	if err := syscall.GetConsoleMode(h, &mode); err != nil {
+		return false
+	}
+	if mode&enableVirtualTerminalProcessing != 0 {
+		return true
+	}
+	setConsoleMode := syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+	ok, _, _ := setConsoleMode.Call(uintptr(h), uintptr(mode|enableVirtualTerminalProcessing))
+	return ok != 0
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// fixedConfig holds the settings that need a restart (ports, target...).
+// GET /api/config shows them; PUT answers 422 if asked to change one.
+var fixedConfig = map[string]any{}
+
+// runtimeConfig is the part of the configuration PUT /api/config can
+// change. Fields are pointers so a PUT only touches what it names.
+type runtimeConfig struct {
+	HistorySize   *int      `json:"history_size,omitempty"`
+	MaxBody       *int64    `json:"max_body,omitempty"`
+	CompactBodies *bool     `json:"compact_bodies,omitempty"`
+	CaptureOnly   *[]string `json:"capture_only,omitempty"`
+	Ignore        *[]string `json:"ignore,omitempty"`
+	CapturePaused *bool     `json:"capture_paused,omitempty"`
+}
+
+func ruleSpecs(rules []*captureRule) []string {
+	specs := []string{}
+	for _, c := range rules {
+		specs = append(specs, c.spec())
+	}
+	return specs
+}
+
+func currentConfig() runtimeConfig {
+	historyMutex.Lock()
+	size := maxHistory
+	historyMutex.Unlock()
+	captureMu.Lock()
+	only, ignore := ruleSpecs(captureRules), ruleSpecs(ignoreRules)
+	captureMu.Unlock()
+	body, compact, paused := maxBody.Load(), compactBodies.Load(), capturePaused.Load()
+	return runtimeConfig{&size, &body, &compact, &only, &ignore, &paused}
+}
+
+func parseRules(specs []string) ([]*captureRule, error) {
+	var rules []*captureRule
+	for _, spec := range specs {
+		rule, err := parseCaptureRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// handleConfigAPI serves GET /api/config and PUT /api/config. A PUT is
+// validated as a whole before anything is applied, and the changes are
+// announced to websocket clients as a {"type":"config"} event.
+func handleConfigAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		body, _ := io.ReadAll(r.Body)
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal(body, &raw); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		for name := range raw {
+			if _, ok := fixedConfig[name]; ok {
+				http.Error(w, fmt.Sprintf("%s can't be changed at runtime; restart ProxyEye", name), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		var req runtimeConfig
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := applyConfig(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"settings": currentConfig(), "fixed": fixedConfig})
+}
+
+func applyConfig(req runtimeConfig) error {
+	if req.HistorySize != nil && *req.HistorySize < 1 {
+		return fmt.Errorf("history_size must be at least 1")
+	}
+	if req.MaxBody != nil && *req.MaxBody < 0 {
+		return fmt.Errorf("max_body must not be negative")
+	}
+	var only, ignore []*captureRule
+	var err error
+	if req.CaptureOnly != nil {
+		if only, err = parseRules(*req.CaptureOnly); err != nil {
+			return fmt.Errorf("capture_only: %v", err)
+		}
+	}
+	if req.Ignore != nil {
+		if ignore, err = parseRules(*req.Ignore); err != nil {
+			return fmt.Errorf("ignore: %v", err)
+		}
+	}
+
+	before := currentConfig()
+	if req.HistorySize != nil {
+		historyMutex.Lock()
+		maxHistory = *req.HistorySize
+		var evicted []uint64
+		if len(history) > maxHistory {
+			evicted = historySeqs(history[:len(history)-maxHistory])
+			history = slices.Clone(history[len(history)-maxHistory:])
+		}
+		historyMutex.Unlock()
+		if len(evicted) > 0 {
+			broadcastEvent(map[string]any{"type": "entry_evicted", "seqs": evicted})
+		}
+	}
+	if req.MaxBody != nil {
+		maxBody.Store(*req.MaxBody)
+	}
+	if req.CompactBodies != nil {
+		compactBodies.Store(*req.CompactBodies)
+	}
+	captureMu.Lock()
+	if req.CaptureOnly != nil {
+		captureRules = only
+	}
+	if req.Ignore != nil {
+		ignoreRules = ignore
+	}
+	captureMu.Unlock()
+	if req.CapturePaused != nil {
+		setCapturePaused(*req.CapturePaused)
+	}
+
+	// Audit: report each setting whose value actually changed.
+	after := currentConfig()
+	changed := map[string][2]any{}
+	bv, av := reflect.ValueOf(before), reflect.ValueOf(after)
+	for i := range bv.NumField() {
+		old, cur := bv.Field(i).Elem().Interface(), av.Field(i).Elem().Interface()
+		if !reflect.DeepEqual(old, cur) {
+			name, _, _ := strings.Cut(bv.Type().Field(i).Tag.Get("json"), ",")
+			changed[name] = [2]any{old, cur}
+		}
+	}
+	if len(changed) > 0 {
+		broadcastEvent(map[string]any{"type": "config", "changed": changed})
+	}
+	return nil
+}
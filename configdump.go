@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Exporting the configuration: GET /__proxyeye/config and -dump-config
+// give the flags that reproduce a session, as JSON and as a command line.
+
+// secretFlags have their values replaced in the dump, which is meant to
+// be shared. -replay-vars keeps the variable names.
+var secretFlags = map[string]bool{"token": true, "hook-url": true, "notify-url": true, "replay-vars": true}
+
+// undumpedFlags are left out: -quiet is another name for -no-cli.
+var undumpedFlags = map[string]bool{"quiet": true, "dump-config": true}
+
+const redacted = "REDACTED"
+
+type configDump struct {
+	// Flags holds those not at their default: a string, or a list of
+	// strings for repeatable flags.
+	Flags   map[string]any `json:"flags"`
+	Args    []string       `json:"args,omitempty"`
+	Command string         `json:"command"`
+	// Settings is what GET /api/config reports; history_size has no flag.
+	Settings *runtimeConfig `json:"settings,omitempty"`
+}
+
+// dumpConfig collects the non-default flags. With live set, the settings
+// changed through PUT /api/config replace the startup values.
+func dumpConfig(live bool) configDump {
+	d := configDump{Flags: map[string]any{}, Args: flag.Args()}
+	var runtime runtimeConfig
+	if live {
+		runtime = currentConfig()
+		d.Settings = &runtime
+	}
+	cmd := []string{"proxyeye"}
+	flag.VisitAll(func(f *flag.Flag) {
+		if undumpedFlags[f.Name] {
+			return
+		}
+		var values []string
+		list, repeatable := f.Value.(*stringList)
+		if repeatable {
+			values = slices.Clone(*list)
+		} else if v := f.Value.String(); v != f.DefValue {
+			values = []string{v}
+		}
+		if live {
+			switch f.Name {
+			case "max-body":
+				values = []string{strconv.FormatInt(*runtime.MaxBody, 10)}
+			case "compact-bodies":
+				values = []string{strconv.FormatBool(*runtime.CompactBodies)}
+			case "capture-only":
+				values = *runtime.CaptureOnly
+			case "ignore":
+				values = *runtime.Ignore
+			}
+			if !repeatable && len(values) == 1 && values[0] == f.DefValue {
+				values = nil
+			}
+		}
+		if len(values) == 0 {
+			return
+		}
+		if secretFlags[f.Name] {
+			for i, v := range values {
+				if name, _, ok := strings.Cut(v, "="); ok && f.Name == "replay-vars" {
+					values[i] = name + "=" + redacted
+				} else {
+					values[i] = redacted
+				}
+			}
+		}
+		if repeatable {
+			d.Flags[f.Name] = values
+		} else {
+			d.Flags[f.Name] = values[0]
+		}
+		for _, v := range values {
+			if isBoolFlag(f) && v == "true" {
+				cmd = append(cmd, "-"+f.Name)
+			} else {
+				cmd = append(cmd, "-"+f.Name+"="+shellQuote(v))
+			}
+		}
+	})
+	for _, a := range d.Args {
+		cmd = append(cmd, shellQuote(a))
+	}
+	d.Command = strings.Join(cmd, " ")
+	return d
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+var shellSafe = regexp.MustCompile(`^[\w@%+=:,./-]+$`)
+
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// handleConfigDump serves GET /__proxyeye/config: the session's flags as
+// JSON, or as a command line with ?format=flags.
+func handleConfigDump(w http.ResponseWriter, r *http.Request) {
+	d := dumpConfig(true)
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	case "flags":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(d.Command + "\n"))
+	default:
+		http.Error(w, "format must be json or flags", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import "net/http"
+
+var (
+	corsEnabled  bool // -cors: answer preflights and allow every origin
+	corsOverride bool // -cors-override: replace CORS headers the target set
+)
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// applyCORSPreflight answers CORS preflights itself, allowing whatever the
+// browser asked for. It reports whether r was answered.
+func applyCORSPreflight(w http.ResponseWriter, r *http.Request, info *requestInfo) bool {
+	if !corsEnabled || !isPreflight(r) {
+		return false
+	}
+	header := http.Header{}
+	header.Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+	header.Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
+	if h := r.Header.Get("Access-Control-Request-Headers"); h != "" {
+		header.Set("Access-Control-Allow-Headers", h)
+	}
+	header.Set("Access-Control-Allow-Credentials", "true")
+	header.Set("Vary", "Origin")
+	info.corsPreflight = true
+	respondSynthetic(w, r, http.StatusNoContent, header, nil)
+	return true
+}
+
+// injectCORS allows the request's origin on a proxied response, leaving
+// headers the target already set alone unless -cors-override is given.
+func injectCORS(resp *http.Response) {
+	origin := resp.Request.Header.Get("Origin")
+	if !corsEnabled || origin == "" {
+		return
+	}
+	for k, v := range map[string]string{
+		"Access-Control-Allow-Origin":      origin,
+		"Access-Control-Allow-Credentials": "true",
+	} {
+		if corsOverride || resp.Header.Get(k) == "" {
+			resp.Header.Set(k, v)
+		}
+	}
+	resp.Header.Add("Vary", "Origin")
+}
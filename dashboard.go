@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cliMode is -cli-mode: "stream" prints a line per request, "dashboard"
+// redraws the last requests in place under a live stats line, like htop.
+var cliMode = "stream"
+
+// dashboardRate is the window requests per second are averaged over.
+const dashboardRate = 10 * time.Second
+
+// stty runs stty on the terminal ProxyEye was started from.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// startCompactDashboard runs -cli-mode dashboard. Without a terminal it
+// streams lines as usual, since redrawing would only fill a log with
+// escape codes.
+func startCompactDashboard(target, targetURL, customDomain string) {
+	if !ansiOut {
+		startCLIDashboard(target, targetURL, customDomain)
+		return
+	}
+	d := &compactDashboard{header: fmt.Sprintf("Domain: %s | Forwarding: %s", customDomain, targetURL)}
+	// Hidden cursor and no line wrapping while it runs: long lines are cut
+	// at the edge instead of scrolling the screen.
+	fmt.Print("\033[?25l\033[?7l\033[H\033[2J")
+	tuiMu.Lock()
+	tuiRestore = func() {
+		fmt.Printf("\033[?7h\033[?25h\033[%d;1H\n", d.rows)
+	}
+	tuiMu.Unlock()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		d.draw()
+		select {
+		case e := <-cliChan:
+			d.add(e)
+			for drained := false; !drained; { // redraw once per burst
+				select {
+				case e := <-cliChan:
+					d.add(e)
+				default:
+					drained = true
+				}
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+type compactDashboard struct {
+	header  string
+	recent  []CombinedLog // newest last, as many as fit on screen
+	arrived []time.Time   // arrival times within dashboardRate, for the rate
+	total   int
+	errors  int // 4xx and 5xx
+	latency float64
+	rows    int
+}
+
+func (d *compactDashboard) add(e CombinedLog) {
+	d.total++
+	if e.Status >= 400 {
+		d.errors++
+	}
+	ms, _ := strconv.ParseFloat(strings.TrimSuffix(e.Latency, "ms"), 64)
+	d.latency += ms
+	d.arrived = append(d.arrived, time.Now())
+	d.recent = append(d.recent, e)
+	// Keep enough for the tallest screen it is likely to be resized to.
+	if len(d.recent) > 500 {
+		d.recent = d.recent[len(d.recent)-500:]
+	}
+}
+
+// statsLine summarizes the session so far.
+func (d *compactDashboard) statsLine() string {
+	cutoff := time.Now().Add(-dashboardRate)
+	for len(d.arrived) > 0 && d.arrived[0].Before(cutoff) {
+		d.arrived = d.arrived[1:]
+	}
+	line := fmt.Sprintf("Requests: %d | Rate: %.1f/s", d.total, float64(len(d.arrived))/dashboardRate.Seconds())
+	if d.total > 0 {
+		line += fmt.Sprintf(" | Avg latency: %.2fms", d.latency/float64(d.total))
+	}
+	if d.errors > 0 {
+		line += " | " + colorize("31", fmt.Sprintf("Errors: %d", d.errors))
+	}
+	return line
+}
+
+func (d *compactDashboard) draw() {
+	d.rows = 24
+	if size, err := stty("size"); err == nil {
+		if r, _, ok := strings.Cut(size, " "); ok {
+			if n, err := strconv.Atoi(r); err == nil && n > 0 {
+				d.rows = n
+			}
+		}
+	}
+	var b strings.Builder
+	line := func(row int, s string) {
+		fmt.Fprintf(&b, "\033[%d;1H\033[2K%s\033[0m", row, s)
+	}
+	line(1, statusLine())
+	line(2, d.header)
+	line(3, d.statsLine())
+	line(4, "")
+	line(5, "HTTP Requests")
+	line(6, "-------------")
+	const top = 7
+	n := max(1, d.rows-top+1)
+	shown := d.recent[max(0, len(d.recent)-n):]
+	for i := range n {
+		s := ""
+		if i < len(shown) {
+			s = dashboardLine(shown[i])
+		}
+		line(top+i, s)
+	}
+	os.Stdout.WriteString(b.String())
+}
+
+// dashboardLine is e's line, from -cli-template if there is one.
+func dashboardLine(e CombinedLog) string {
+	if cliTemplate == nil {
+		return cliLine(e)
+	}
+	var b bytes.Buffer
+	if err := cliTemplate.Execute(&b, e); err != nil {
+		return "-cli-template: " + err.Error()
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// debugEnabled (-debug) serves pprof and expvar under /debug on the
+// inspector listener. Importing those packages registers them on
+// http.DefaultServeMux, which ProxyEye doesn't serve, so they stay
+// unreachable unless registerDebug adds them to the real mux.
+var debugEnabled bool
+
+func registerDebug(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	expvar.Publish("proxyeye", expvar.Func(debugVars))
+}
+
+// debugVars is the "proxyeye" expvar, computed when /debug/vars is read.
+func debugVars() any {
+	historyMutex.Lock()
+	entries, bytes := len(history), 0
+	for _, e := range history {
+		bytes += len(e.ReqHeaders) + len(e.ReqBody) + len(e.RespHeaders) + len(e.RespBody)
+	}
+	historyMutex.Unlock()
+
+	clientsMu.Lock()
+	queued := 0
+	for c := range clients {
+		queued += len(c.send)
+	}
+	wsClients := len(clients)
+	clientsMu.Unlock()
+
+	return map[string]any{
+		"history_entries":    entries,
+		"history_bytes":      bytes, // headers and bodies, approximately
+		"broadcast_queue":    len(broadcast),
+		"ws_clients":         wsClients,
+		"ws_queued_messages": queued,
+		"goroutines":         runtime.NumGoroutine(),
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Volatile parts of a response left out of replay diffs.
+var (
+	diffIgnoreHeaders = []string{"Date", "X-Request-Id", "X-Correlation-Id", "Age", "Content-Length"}
+	diffIgnoreFields  = []string{"timestamp", "created_at", "updated_at"}
+)
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// responseDiff is what changed between a captured response and its replay.
+type responseDiff struct {
+	Identical      bool                 `json:"identical"`
+	Status         []int                `json:"status,omitempty"` // [old, new] when different
+	HeadersAdded   map[string][]string  `json:"headers_added,omitempty"`
+	HeadersRemoved map[string][]string  `json:"headers_removed,omitempty"`
+	HeadersChanged map[string][2]string `json:"headers_changed,omitempty"`
+	Body           []bodyChange         `json:"body,omitempty"`
+	Note           string               `json:"note,omitempty"`
+}
+
+// bodyChange is one difference in the body. For JSON bodies Path points at
+// the field ("$.user.name", "$.items[2]"); otherwise it is "$".
+type bodyChange struct {
+	Path string `json:"path"`
+	Op   string `json:"op"` // "added", "removed" or "changed"
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+func diffHeaders(d *responseDiff, old, cur http.Header) {
+	for _, h := range diffIgnoreHeaders {
+		old.Del(h)
+		cur.Del(h)
+	}
+	for k, ov := range old {
+		nv, ok := cur[k]
+		switch {
+		case !ok:
+			if d.HeadersRemoved == nil {
+				d.HeadersRemoved = map[string][]string{}
+			}
+			d.HeadersRemoved[k] = ov
+		case !slices.Equal(ov, nv):
+			if d.HeadersChanged == nil {
+				d.HeadersChanged = map[string][2]string{}
+			}
+			d.HeadersChanged[k] = [2]string{strings.Join(ov, ", "), strings.Join(nv, ", ")}
+		}
+	}
+	for k, nv := range cur {
+		if _, ok := old[k]; !ok {
+			if d.HeadersAdded == nil {
+				d.HeadersAdded = map[string][]string{}
+			}
+			d.HeadersAdded[k] = nv
+		}
+	}
+}
+
+// diffJSON appends the differences between old and cur below path.
+func diffJSON(changes []bodyChange, path string, old, cur any) []bodyChange {
+	switch o := old.(type) {
+	case map[string]any:
+		n, ok := cur.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(o)+len(n))
+		for k := range o {
+			keys = append(keys, k)
+		}
+		for k := range n {
+			if _, ok := o[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			if slices.Contains(diffIgnoreFields, k) {
+				continue
+			}
+			ov, inOld := o[k]
+			nv, inNew := n[k]
+			switch {
+			case !inNew:
+				changes = append(changes, bodyChange{Path: path + "." + k, Op: "removed", Old: ov})
+			case !inOld:
+				changes = append(changes, bodyChange{Path: path + "." + k, Op: "added", New: nv})
+			default:
+				changes = diffJSON(changes, path+"."+k, ov, nv)
+			}
+		}
+		return changes
+	case []any:
+		n, ok := cur.([]any)
+		if !ok {
+			break
+		}
+		for i := range max(len(o), len(n)) {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(n):
+				changes = append(changes, bodyChange{Path: p, Op: "removed", Old: o[i]})
+			case i >= len(o):
+				changes = append(changes, bodyChange{Path: p, Op: "added", New: n[i]})
+			default:
+				changes = diffJSON(changes, p, o[i], n[i])
+			}
+		}
+		return changes
+	}
+	if !reflect.DeepEqual(old, cur) {
+		changes = append(changes, bodyChange{Path: path, Op: "changed", Old: old, New: cur})
+	}
+	return changes
+}
+
+// plainBody returns a stored or replayed body with any gzip/deflate
+// encoding undone.
+func plainBody(body []byte, header http.Header) []byte {
+	if plain, ok := decodeContent(header.Get("Content-Encoding"), body); ok {
+		return plain
+	}
+	return body
+}
+
+// diffResponses compares the captured response in e with a replay result.
+func diffResponses(e CombinedLog, res replayResult) *responseDiff {
+	d := &responseDiff{}
+	if e.Status != res.Status {
+		d.Status = []int{e.Status, res.Status}
+	}
+	oldHeader := parseHeaderDump(e.RespHeaders)
+	oldBody := plainBody(decodeBody(e.RespBody, e.RespBodyEncoding), oldHeader)
+	newBody := plainBody([]byte(res.Body), res.Headers)
+	diffHeaders(d, oldHeader, res.Headers.Clone())
+
+	var oldJSON, newJSON any
+	if e.RespTruncated {
+		d.Note = "body not compared: the captured body was truncated at -max-body"
+	} else if json.Unmarshal(oldBody, &oldJSON) == nil && json.Unmarshal(newBody, &newJSON) == nil {
+		d.Body = diffJSON(nil, "$", oldJSON, newJSON)
+	} else if string(oldBody) != string(newBody) {
+		d.Body = []bodyChange{{Path: "$", Op: "changed", Old: string(oldBody), New: string(newBody)}}
+	}
+	d.Identical = d.Status == nil && d.HeadersAdded == nil && d.HeadersRemoved == nil &&
+		d.HeadersChanged == nil && d.Body == nil
+	return d
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// exportBaseURL is the collection's {{baseUrl}}: the proxied target.
+var exportBaseURL string
+
+// Postman Collection v2.1 (https://schema.getpostman.com/json/collection/v2.1.0/).
+type postmanCollection struct {
+	Info     postmanInfo       `json:"info"`
+	Item     []postmanFolder   `json:"item"`
+	Variable []postmanKeyValue `json:"variable"`
+}
+
+type postmanInfo struct {
+	Name   string `json:"name"`
+	Schema string `json:"schema"`
+}
+
+type postmanFolder struct {
+	Name string        `json:"name"`
+	Item []postmanItem `json:"item"`
+}
+
+type postmanItem struct {
+	Name     string            `json:"name"`
+	Request  postmanRequest    `json:"request"`
+	Response []postmanResponse `json:"response"`
+}
+
+type postmanRequest struct {
+	Method string            `json:"method"`
+	Header []postmanKeyValue `json:"header"`
+	URL    postmanURL        `json:"url"`
+	Body   *postmanBody      `json:"body,omitempty"`
+}
+
+type postmanURL struct {
+	Raw   string            `json:"raw"`
+	Host  []string          `json:"host"`
+	Path  []string          `json:"path"`
+	Query []postmanKeyValue `json:"query,omitempty"`
+}
+
+type postmanBody struct {
+	Mode string `json:"mode"`
+	Raw  string `json:"raw"`
+}
+
+type postmanResponse struct {
+	Name            string            `json:"name"`
+	OriginalRequest postmanRequest    `json:"originalRequest"`
+	Status          string            `json:"status"`
+	Code            int               `json:"code"`
+	Header          []postmanKeyValue `json:"header"`
+	Body            string            `json:"body"`
+}
+
+type postmanKeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// idSegmentRe matches path segments that are IDs rather than routes.
+var idSegmentRe = regexp.MustCompile(`^(\d+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-fA-F]{24,})$`)
+
+// normalizePath replaces ID-like segments with ":id", so /users/1 and
+// /users/2 end up in the same folder.
+func normalizePath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, s := range segs {
+		if idSegmentRe.MatchString(s) {
+			segs[i] = ":id"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+func postmanHeaders(dump string, decoded bool) []postmanKeyValue {
+	out := []postmanKeyValue{}
+	h := parseHeaderDump(dump)
+	h.Del(loopHeader)
+	if decoded {
+		h.Del("Content-Encoding") // the exported body is the decompressed one
+	}
+	for k, vs := range h {
+		switch k {
+		case "Content-Length", "Connection", "Transfer-Encoding", "Host", "X-Forwarded-For":
+			continue // set by the client or by ProxyEye itself
+		}
+		for _, v := range vs {
+			out = append(out, postmanKeyValue{k, v})
+		}
+	}
+	return out
+}
+
+func postmanRequestFor(e CombinedLog) postmanRequest {
+	host := "{{baseUrl}}"
+	if p := proxyByName[e.Proxy]; p != nil {
+		host = strings.TrimSuffix(p.Target, "/")
+	}
+	if e.Host != "" {
+		host = "http://" + e.Host
+	}
+	req := postmanRequest{
+		Method: e.Method,
+		Header: postmanHeaders(e.ReqHeaders, e.ReqDecoded != ""),
+		URL: postmanURL{
+			Raw:  host + e.Path,
+			Host: []string{host},
+			Path: strings.Split(strings.TrimPrefix(e.Path, "/"), "/"),
+		},
+	}
+	if e.QueryString != "" {
+		req.URL.Raw += "?" + e.QueryString
+		for _, kv := range strings.Split(e.QueryString, "&") {
+			k, v, _ := strings.Cut(kv, "=")
+			k, _ = url.QueryUnescape(k)
+			v, _ = url.QueryUnescape(v)
+			req.URL.Query = append(req.URL.Query, postmanKeyValue{k, v})
+		}
+	}
+	// Binary bodies can't be represented as raw text.
+	if e.ReqBody != "" && e.ReqBodyEncoding == "" {
+		req.Body = &postmanBody{Mode: "raw", Raw: e.ReqBody}
+	}
+	return req
+}
+
+// handleExportPostman converts history into a Postman collection, one
+// folder per normalized path with each captured response as an example.
+func handleExportPostman(w http.ResponseWriter, r *http.Request) {
+	historyMutex.Lock()
+	entries := append([]CombinedLog(nil), history...)
+	historyMutex.Unlock()
+
+	coll := postmanCollection{
+		Info: postmanInfo{
+			Name:   "ProxyEye capture",
+			Schema: "https://schema.getpostman.com/json/collection/v2.1.0/collection.json",
+		},
+		Item:     []postmanFolder{},
+		Variable: []postmanKeyValue{{"baseUrl", exportBaseURL}},
+	}
+	folders := map[string]int{}
+	for _, e := range entries {
+		name := normalizePath(e.Path)
+		i, ok := folders[name]
+		if !ok {
+			i = len(coll.Item)
+			folders[name] = i
+			coll.Item = append(coll.Item, postmanFolder{Name: name})
+		}
+		req := postmanRequestFor(e)
+		resp := postmanResponse{
+			Name:            e.Method + " " + e.Path + " (" + e.TimeISO + ")",
+			OriginalRequest: req,
+			Status:          http.StatusText(e.Status),
+			Code:            e.Status,
+			Header:          postmanHeaders(e.RespHeaders, false),
+		}
+		if e.RespBodyEncoding == "" {
+			resp.Body = e.RespBody
+		}
+		coll.Item[i].Item = append(coll.Item[i].Item, postmanItem{
+			Name:     e.Method + " " + e.Path,
+			Request:  req,
+			Response: []postmanResponse{resp},
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="proxyeye.postman_collection.json"`)
+	json.NewEncoder(w).Encode(coll)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// fixturesDir is -fixtures: a VCR-style cassette library. A request with a
+// fixture on disk is answered from it; any other is proxied and its
+// response saved as a new fixture, so the next run needs no backend. Like
+// -cache-mode, but kept across restarts and easy to commit with tests.
+var fixturesDir string
+
+// fixture is one recorded response, a JSON file in fixturesDir. The
+// request fields are informational: the file name is what is matched.
+type fixture struct {
+	Method       string      `json:"method"`
+	Path         string      `json:"path"`
+	Query        string      `json:"query,omitempty"`
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header"`
+	Body         string      `json:"body"`
+	BodyEncoding string      `json:"body_encoding,omitempty"` // "base64" for binary bodies
+	Recorded     string      `json:"recorded"`
+}
+
+var fixtureSlug = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// fixturePath names r's fixture after its method and path, for people
+// browsing the directory, and a hash of the method, path and query (and
+// -proxy name) that tells requests apart.
+func fixturePath(r *http.Request) string {
+	key := requestProxyName(r) + " " + r.Method + " " + r.URL.Path + "?" + canonicalQuery(r.URL.RawQuery)
+	sum := sha256.Sum256([]byte(key))
+	slug := strings.Trim(fixtureSlug.ReplaceAllString(r.URL.Path, "_"), "_")
+	if len(slug) > 60 {
+		slug = slug[:60]
+	}
+	if slug == "" {
+		slug = "root"
+	}
+	return filepath.Join(fixturesDir, fmt.Sprintf("%s_%s_%x.json", r.Method, slug, sum[:6]))
+}
+
+// serveFixture answers r from its fixture. On a miss it marks info so
+// storeFixture records the target's response.
+func serveFixture(w http.ResponseWriter, r *http.Request, info *requestInfo) bool {
+	if fixturesDir == "" || info.target != "" {
+		return false // X-ProxyEye-Target requests always reach their backend
+	}
+	path := fixturePath(r)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		info.fixtureFile = path
+		return false
+	}
+	var f fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		log.Printf("-fixtures: %s: %v", path, err)
+		header := http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+		info.source = "fixture"
+		respondSynthetic(w, r, http.StatusInternalServerError, header, []byte("ProxyEye fixtures: can't read "+filepath.Base(path)+"\n"))
+		return true
+	}
+	info.source = "fixture"
+	respondSynthetic(w, r, f.Status, f.Header, decodeBody(f.Body, f.BodyEncoding))
+	return true
+}
+
+// storeFixture saves resp as the fixture for its request. As with the
+// cache, server errors, server-sent events and bodies over -max-body are
+// left out, so a flaky moment isn't frozen into the library.
+func storeFixture(resp *http.Response, info *requestInfo) {
+	if info.fixtureFile == "" || resp.StatusCode >= 500 || isEventStream(resp) {
+		return
+	}
+	limit := maxBody.Load()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	if err != nil || int64(len(body)) > limit {
+		return
+	}
+	header := resp.Header.Clone()
+	for _, h := range []string{"Content-Length", "Transfer-Encoding", "Connection"} {
+		header.Del(h)
+	}
+	r := resp.Request
+	f := fixture{
+		Method: r.Method, Path: info.originalPath, Query: r.URL.RawQuery,
+		Status: resp.StatusCode, Header: header, Recorded: time.Now().Format(time.RFC3339),
+	}
+	f.Body, f.BodyEncoding = encodeBody(string(body))
+	if err := writeFixture(info.fixtureFile, f); err != nil {
+		log.Printf("-fixtures: %v", err)
+	}
+}
+
+// writeFixture writes via a temporary file and rename, so concurrent
+// requests for the same fixture never leave a half-written file.
+func writeFixture(path string, f fixture) error {
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	enc.SetEscapeHTML(false) // fixtures are meant to be read and edited
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(f); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".fixture-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
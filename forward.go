@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// forwardProxy forwards absolute-form requests ("GET http://host/path") as
+// sent by clients configured with HTTP_PROXY.
+var forwardProxy = &httputil.ReverseProxy{
+	// The outgoing URL is already absolute; just keep the client's Host.
+	Director:       markForwarded,
+	ModifyResponse: modifyResponse,
+	Transport:      proxyTransport,
+	ErrorHandler:   proxyErrorHandler,
+}
+
+// tunnelDialTimeout bounds connecting to a CONNECT destination.
+const tunnelDialTimeout = 10 * time.Second
+
+// withForwardProxy routes forward-proxy traffic away from the inspector's
+// own routes, which would otherwise shadow paths such as /history on the
+// destination host.
+func withForwardProxy(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodConnect:
+			serveTunnel(w, r)
+		case r.URL.IsAbs():
+			serveProxied(w, r, forwardProxy)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// serveTunnel handles CONNECT, which clients send for HTTPS: it dials the
+// destination, answers 200 and copies bytes both ways until either side
+// closes. The traffic is encrypted, so the entry, recorded once the tunnel
+// closes, only has the host, the bytes each way and how long it was open.
+func serveTunnel(w http.ResponseWriter, r *http.Request) {
+	r, info, _ := withCaptureContext(r)
+	defer startPending(r, info)()
+	info.tunnel = true
+	upstream, err := net.DialTimeout("tcp", r.Host, tunnelDialTimeout)
+	if err != nil {
+		header := http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+		respondSynthetic(w, r, http.StatusBadGateway, header, []byte("ProxyEye: "+err.Error()+"\n"))
+		return
+	}
+	defer upstream.Close()
+	client, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil { // HTTP/2 can't be hijacked
+		header := http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+		respondSynthetic(w, r, http.StatusNotImplemented, header, []byte("ProxyEye: CONNECT needs HTTP/1.1\n"))
+		return
+	}
+	defer client.Close()
+	client.SetDeadline(time.Time{}) // the server's timeouts were for the CONNECT request
+	if _, err := io.WriteString(client, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return
+	}
+
+	var up, down atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		// Bytes the client sent along with the CONNECT are read first.
+		n, _ := io.Copy(upstream, io.MultiReader(buffered.Reader, client))
+		up.Store(n)
+		closeWrite(upstream)
+	}()
+	go func() {
+		defer wg.Done()
+		n, _ := io.Copy(client, upstream)
+		down.Store(n)
+		closeWrite(client)
+	}()
+	wg.Wait()
+
+	info.tunnelUp = up.Load()
+	resp := &http.Response{
+		Status: "200 Connection Established", StatusCode: http.StatusOK,
+		Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Header: http.Header{}, Body: http.NoBody, Request: r,
+	}
+	if !info.recordable(resp) {
+		skippedCount.Add(1)
+		return
+	}
+	dump, _ := httputil.DumpResponse(resp, false)
+	dumpRequest, _ := httputil.DumpRequest(r, false)
+	recordEntry(resp, string(dump), string(dumpRequest), "", false, down.Load(), info)
+}
+
+// closeWrite half-closes c, so the other side sees EOF but can still
+// finish sending; other connections are closed outright.
+func closeWrite(c net.Conn) {
+	if tc, ok := c.(interface{ CloseWrite() error }); ok {
+		tc.CloseWrite()
+		return
+	}
+	c.Close()
+}
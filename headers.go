@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strings"
+)
+
+// parseHeaderDump recovers the headers from a captured request/response dump
+// (the start line followed by MIME headers), as stored in CombinedLog.
+func parseHeaderDump(dump string) http.Header {
+	tp := textproto.NewReader(bufio.NewReader(strings.NewReader(dump)))
+	if _, err := tp.ReadLine(); err != nil {
+		return http.Header{}
+	}
+	h, _ := tp.ReadMIMEHeader()
+	return http.Header(h)
+}
+
+// sentTrailers returns the trailers that arrived with a response body,
+// once it has been read. Names announced in the Trailer header but never
+// sent are left out.
+func sentTrailers(trailer http.Header) http.Header {
+	var sent http.Header
+	for name, v := range trailer {
+		if len(v) > 0 {
+			if sent == nil {
+				sent = http.Header{}
+			}
+			sent[name] = slices.Clone(v)
+		}
+	}
+	return sent
+}
+
+// Response header edits from -strip-resp-header / -set-resp-header. They
+// are applied after capture, so history keeps what the target sent.
+var (
+	stripRespHeaders []string
+	setRespHeaders   = http.Header{}
+)
+
+// parseHeaderLine parses "Name: value".
+func parseHeaderLine(s string) (name, value string, err error) {
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("expected \"Name: value\", got %q", s)
+	}
+	return http.CanonicalHeaderKey(name), strings.TrimSpace(value), nil
+}
+
+// planHeaderEdits notes on info which response headers will be removed or
+// overridden, before the entry is recorded.
+func planHeaderEdits(resp *http.Response, info *requestInfo) {
+	for _, name := range stripRespHeaders {
+		if _, ok := resp.Header[http.CanonicalHeaderKey(name)]; ok {
+			info.strippedHeaders = append(info.strippedHeaders, http.CanonicalHeaderKey(name))
+		}
+	}
+	for name := range setRespHeaders {
+		info.setHeaders = append(info.setHeaders, name)
+	}
+	slices.Sort(info.setHeaders)
+}
+
+func applyHeaderEdits(resp *http.Response) {
+	for _, name := range stripRespHeaders {
+		resp.Header.Del(name)
+	}
+	for name, v := range setRespHeaders {
+		resp.Header[name] = v
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// hookVersion is bumped whenever the hook payload format changes.
+const hookVersion = 1
+
+var (
+	hookURL     string
+	hookScript  string // -script: Starlark file run in place of the webhook (script.go)
+	hookTimeout = 2 * time.Second
+	hookRoutes  []routeMatcher // empty = every request
+	hookClient  = &http.Client{}
+)
+
+// hookMessage is POSTed to -hook-url once per phase ("request", "response").
+type hookMessage struct {
+	Version  int           `json:"version"`
+	Phase    string        `json:"phase"`
+	Request  hookRequest   `json:"request"`
+	Response *hookResponse `json:"response,omitempty"`
+}
+
+type hookRequest struct {
+	Method  string      `json:"method"`
+	Path    string      `json:"path"`
+	Query   string      `json:"query"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+	// Truncated is set when the body is longer than -max-body: Body is
+	// only its start, and a replacement body is ignored.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type hookResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+}
+
+// hookReply is what the hook may answer with; omitted fields are left
+// unchanged and an empty reply (or 204) means "no changes". A header with an
+// empty value list is removed.
+type hookReply struct {
+	Request *hookReplyRequest `json:"request"`
+	// In the request phase a response short-circuits the target; in the
+	// response phase it patches the response sent to the client.
+	Response *hookReplyResponse `json:"response"`
+}
+
+type hookReplyRequest struct {
+	Method  *string     `json:"method"`
+	Path    *string     `json:"path"`
+	Query   *string     `json:"query"`
+	Headers http.Header `json:"headers"`
+	Body    *string     `json:"body"`
+}
+
+type hookReplyResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers"`
+	Body    *string     `json:"body"`
+}
+
+func hookMatches(r *http.Request) bool {
+	if hookURL == "" && hookScript == "" {
+		return false
+	}
+	if len(hookRoutes) == 0 {
+		return true
+	}
+	for i := range hookRoutes {
+		if hookRoutes[i].matches(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func callHook(msg hookMessage) (*hookReply, error) {
+	if hookScript != "" {
+		return runScript(msg)
+	}
+	payload, _ := json.Marshal(msg)
+	data, err := postHook(payload)
+	if err != nil {
+		return nil, err
+	}
+	var reply hookReply
+	if len(bytes.TrimSpace(data)) == 0 {
+		return &reply, nil
+	}
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return nil, fmt.Errorf("invalid hook reply: %v", err)
+	}
+	return &reply, nil
+}
+
+func postHook(payload []byte) ([]byte, error) {
+	client := *hookClient
+	client.Timeout = hookTimeout
+	resp, err := client.Post(hookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("hook returned %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func patchHeaders(dst, patch http.Header) {
+	for k, v := range patch {
+		if len(v) == 0 {
+			dst.Del(k)
+		} else {
+			dst[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+}
+
+// applyRequestHook sends the request to the hook and applies its changes.
+// It reports whether the hook answered the request itself. Hook failures
+// fail open: the request is forwarded unmodified and the error noted.
+func applyRequestHook(w http.ResponseWriter, r *http.Request, info *requestInfo, reqBody *bodyCapture) bool {
+	if !hookMatches(r) {
+		return false
+	}
+	info.hooked = true
+	// The hook sees as much of a chunked or large upload as -max-body
+	// allows; a body it only saw part of can't be replaced.
+	fillErr := reqBody.fill(r)
+	body, truncated := reqBody.snapshot()
+	truncated = truncated || fillErr != nil
+	reply, err := callHook(hookMessage{
+		Version: hookVersion,
+		Phase:   "request",
+		Request: hookRequest{r.Method, r.URL.Path, r.URL.RawQuery, r.Header, body, truncated},
+	})
+	if err != nil {
+		// Don't pay the timeout twice for the same request.
+		info.hookError = err.Error()
+		info.hooked = false
+		return false
+	}
+	if p := reply.Request; p != nil {
+		if p.Method != nil {
+			r.Method = *p.Method
+		}
+		if p.Path != nil {
+			r.URL.Path, r.URL.RawPath = *p.Path, ""
+		}
+		if p.Query != nil {
+			r.URL.RawQuery = *p.Query
+		}
+		patchHeaders(r.Header, p.Headers)
+		if p.Body != nil {
+			if truncated {
+				info.hookError = "body change ignored: request body exceeds -max-body"
+			} else {
+				r.Body = io.NopCloser(bytes.NewBufferString(*p.Body))
+				r.ContentLength = int64(len(*p.Body))
+				r.Header.Set("Content-Length", strconv.Itoa(len(*p.Body)))
+			}
+		}
+		info.hookNote = "request modified"
+	}
+	if p := reply.Response; p != nil {
+		status := p.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		header := http.Header{}
+		patchHeaders(header, p.Headers)
+		var out string
+		if p.Body != nil {
+			out = *p.Body
+		}
+		info.hookNote = "short-circuit"
+		info.hooked = false // don't run the response phase on our own answer
+		respondSynthetic(w, r, status, header, []byte(out))
+		return true
+	}
+	return false
+}
+
+// applyResponseHook lets the hook patch the response sent to the client.
+// The captured entry keeps what the target originally returned.
+func applyResponseHook(resp *http.Response, body []byte, info *requestInfo) {
+	r := resp.Request
+	reply, err := callHook(hookMessage{
+		Version:  hookVersion,
+		Phase:    "response",
+		Request:  hookRequest{r.Method, r.URL.Path, r.URL.RawQuery, r.Header, "", false},
+		Response: &hookResponse{resp.StatusCode, resp.Header, string(body)},
+	})
+	if err != nil {
+		info.hookError = err.Error()
+		return
+	}
+	p := reply.Response
+	if p == nil {
+		return
+	}
+	if p.Status != 0 {
+		resp.StatusCode = p.Status
+		resp.Status = fmt.Sprintf("%d %s", p.Status, http.StatusText(p.Status))
+	}
+	patchHeaders(resp.Header, p.Headers)
+	if p.Body != nil {
+		resp.Body = io.NopCloser(bytes.NewBufferString(*p.Body))
+		resp.ContentLength = int64(len(*p.Body))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(*p.Body)))
+		resp.Header.Del("Content-Encoding")
+	}
+	if info.hookNote == "" {
+		info.hookNote = "response modified"
+	} else {
+		info.hookNote += ", response modified"
+	}
+}
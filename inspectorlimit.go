@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limits on the inspector's own routes, so a script polling /history in a
+// tight loop can't slow the proxy down. Proxied traffic never goes through
+// them. -api-rate-limit off disables the rate limit.
+var (
+	apiRateLimit = "50rps"
+	apiLimiter   = &rateLimiter{keyFunc: inspectorClient}
+
+	// apiWriteTimeout bounds how long one inspector response may take to
+	// write, e.g. to a client that stopped reading.
+	apiWriteTimeout = 30 * time.Second
+
+	// exportSlots caps the exports and body downloads running at once:
+	// each copies history or a body into memory.
+	exportSlots = make(chan struct{}, 2)
+)
+
+// inspectorClient keys the API rate limit by token when a valid one is
+// sent (each share link gets its own budget) and by IP otherwise. The
+// limiter runs before withAuth, so an unchecked token would let a caller
+// skip the limit by sending a new one with each request.
+func inspectorClient(r *http.Request) string {
+	if t := requestToken(r); t != "" && validToken(t) {
+		return "token " + t
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// withInspectorLimits applies the API rate limit and write timeout to
+// inspector routes other than the page, its -ui-dir assets and the live
+// feeds, /ws and /stream, which are long-lived and have their own
+// keepalives.
+func withInspectorLimits(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isInspectorRoute(mux, r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, pattern := mux.Handler(r); pattern == "/ws" || pattern == "GET /stream" || pattern == "/inspect" || isUIAsset(mux, r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := apiLimiter.allow(r); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "ProxyEye API rate limit exceeded (-api-rate-limit)", http.StatusTooManyRequests)
+			return
+		}
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(apiWriteTimeout))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitExports runs h only while an export slot is free, answering 429
+// otherwise.
+func limitExports(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case exportSlots <- struct{}{}:
+			defer func() { <-exportSlots }()
+			h(w, r)
+		default:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many exports running; try again shortly", http.StatusTooManyRequests)
+		}
+	}
+}
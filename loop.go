@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// loopHeader lists the ProxyEye instances a request went through: each
+// adds its own ID when forwarding. Seeing this process's ID on an incoming
+// request means the target led straight back to it; another instance's ID
+// is just a chained ProxyEye, e.g. one in front of a service and one in
+// front of the app.
+const loopHeader = "X-ProxyEye"
+
+// instanceID tells this process apart from other ProxyEye instances.
+var instanceID = func() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}()
+
+func markForwarded(r *http.Request) {
+	r.Header.Add(loopHeader, instanceID)
+}
+
+// rejectLoop answers 508 Loop Detected to requests ProxyEye sent itself,
+// instead of forwarding them again until resources run out.
+func rejectLoop(w http.ResponseWriter, r *http.Request) bool {
+	if !seenBefore(r.Header) {
+		return false
+	}
+	log.Printf("proxy loop detected: %s %s came back to ProxyEye; check the target address", r.Method, r.URL)
+	respondSynthetic(w, r, http.StatusLoopDetected, http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		[]byte("ProxyEye: proxy loop detected, the target points back at ProxyEye\n"))
+	return true
+}
+
+// seenBefore reports whether h carries this process's ID, in any of the
+// header's lines or comma-separated values.
+func seenBefore(h http.Header) bool {
+	for _, v := range h.Values(loopHeader) {
+		if slices.Contains(strings.Split(strings.ReplaceAll(v, " ", ""), ","), instanceID) {
+			return true
+		}
+	}
+	return false
+}
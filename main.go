@@ -3,22 +3,44 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"embed"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// checkPorts rejects a target that is ProxyEye's own listener: the UI and
+// the proxy share that port, so proxying to it would loop forever.
+func checkPorts(listen, target string) error {
+	t, err := strconv.Atoi(target)
+	if err != nil || t <= 0 || t > 65535 {
+		return fmt.Errorf("invalid target port %q", target)
+	}
+	if l, err := strconv.Atoi(listen); err == nil && l == t {
+		return fmt.Errorf("target port %d is ProxyEye's own port (-ui); pick a different -ui or target port", t)
+	}
+	return nil
+}
+
 func printLogo() {
 	logo := `
   _____                      ______             
@@ -31,7 +53,7 @@ func printLogo() {
                       |___/        |___/        `
 
 	// Print in Cyan using ANSI colors
-	fmt.Printf("\033[36m%s\033[0m\n", logo)
+	fmt.Println(colorize("36", logo))
 	fmt.Println(" =============================================")
 }
 
@@ -41,28 +63,134 @@ var staticFiles embed.FS
 // Define a custom type for context keys to avoid collisions
 type key string
 
-const startTimeKey key = "startTime"
+const (
+	startTimeKey key = "startTime"
+	reqInfoKey   key = "reqInfo"
+	proxyNameKey key = "proxyName" // set by -proxy listeners
+	reqBodyKey   key = "capturedReqBody"
+)
+
+// requestInfo carries per-request annotations from the "/" handler to
+// captureResponse so they end up on the CombinedLog entry.
+type requestInfo struct {
+	injectedDelay time.Duration
+	injectedFault string
+	rateLimited   bool
+	throttle      string
+	source        string
+	host          string
+	hooked        bool
+	hookNote      string
+	hookError     string
+	replayed      bool
+	probe         int
+	rewrite       string
+	corsPreflight bool
+	tag           string
+	target        string // from X-ProxyEye-Target
+	attempts      int    // upstream tries, with -retries
+	proxy         string // -proxy name the request arrived on
+	cacheKey      string // on a -cache-mode miss: store the target's response under this key
+	fixtureFile   string // on a -fixtures miss: record the target's response here
+	mirrorPair    uint64 // shared with the -mirror copy's entry
+	originalPath  string // as the client sent it, before -rewrite and -target-path
+	requestID     uint64 // from request_started, when it was announced
+	upstreamErr   string // why the proxy couldn't get a response
+	clientError   string // the first failed write to the client
+	recorded      atomic.Bool
+	tunnel        bool  // a CONNECT tunnel
+	tunnelUp      int64 // bytes the client sent through it
+
+	strippedHeaders, setHeaders []string
+
+	respChunks []chunkRead // with -capture-chunks
+
+	reqReplacements, respReplacements *int           // nil when no -replace rule applied
+	finish                            func()         // run after the upstream handler returns
+	skip                              bool           // not recorded (-capture-only / -ignore)
+	captureType                       *regexp.Regexp // response Content-Type required for recording
+}
+
+// recordable finishes the capture decision once the response is known.
+func (info *requestInfo) recordable(resp *http.Response) bool {
+	if info.skip {
+		return false
+	}
+	ct := resp.Header.Get("Content-Type")
+	if info.captureType != nil && !info.captureType.MatchString(ct) {
+		return false
+	}
+	return !responseIgnored(resp.Request, ct)
+}
 
 var (
-	upgrader  = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
-	clients   = make(map[*websocket.Conn]bool)
+	upgrader  = websocket.Upgrader{CheckOrigin: checkWSOrigin, EnableCompression: true}
+	clients   = make(map[*wsClient]bool)
 	clientsMu sync.Mutex
-	broadcast = make(chan CombinedLog)
+	// Both are buffered and only ever sent to without blocking: a stalled
+	// consumer costs entries, never proxied responses.
+	broadcast = make(chan CombinedLog, 1024)
 	// Create a separate channel for CLI
-	cliChan = make(chan CombinedLog)
+	cliChan = make(chan CombinedLog, 256)
 )
 
 type CombinedLog struct {
+	Seq         uint64 `json:"seq"` // publication order, for /ws?since=
 	Method      string `json:"method"`
 	QueryString string `json:"query_string"`
 	Path        string `json:"path"`
 	ReqHeaders  string `json:"req_headers"`
 	Status      int    `json:"status"`
+	StatusText  string `json:"status_text,omitempty"` // e.g. "Not Found"; empty for codes Go doesn't know
 	ReqBody     string `json:"req_body"`
 	RespHeaders string `json:"resp_headers"`
 	RespBody    string `json:"resp_body"`
 	Latency     string `json:"latency"`
 	Time        string `json:"time"`
+	TimeISO     string `json:"time_iso"`   // RFC 3339 with date and milliseconds
+	StartTime   int64  `json:"start_time"` // epoch ms when the request arrived
+	EndTime     int64  `json:"end_time"`   // epoch ms when the response finished
+	ReqBytes    int64  `json:"req_bytes"`  // body sizes on the wire, including
+	RespBytes   int64  `json:"resp_bytes"` // anything -max-body cut off
+
+	ReqTruncated     bool        `json:"req_body_truncated,omitempty"`
+	ReqBodyEncoding  string      `json:"req_body_encoding,omitempty"` // "base64" for binary bodies
+	ReqDecoded       string      `json:"req_decoded,omitempty"`       // request Content-Encoding undone for ReqBody; the target got the encoded bytes
+	RespTruncated    bool        `json:"resp_body_truncated,omitempty"`
+	RespBodyEncoding string      `json:"resp_body_encoding,omitempty"`
+	InjectedDelayMs  int64       `json:"injected_delay_ms,omitempty"`
+	RealLatencyMs    float64     `json:"real_latency_ms,omitempty"` // latency without the injected delay
+	InjectedFault    string      `json:"injected_fault,omitempty"`
+	RateLimited      bool        `json:"rate_limited,omitempty"`
+	Throttle         string      `json:"throttle,omitempty"`
+	Source           string      `json:"source,omitempty"`        // set when not answered by the target, e.g. "replay" or "fixture"
+	Host             string      `json:"host,omitempty"`          // destination host in forward-proxy mode
+	Client           string      `json:"client,omitempty"`        // the client's address
+	Tunnel           bool        `json:"tunnel,omitempty"`        // a CONNECT tunnel: only host, bytes each way and duration are known
+	Replayed         bool        `json:"replayed,omitempty"`      // re-sent from the inspector
+	Probe            int         `json:"probe,omitempty"`         // ID of the probe that sent it
+	Rewrite          string      `json:"rewrite,omitempty"`       // -rewrite rule applied (with -rewrite-log)
+	OriginalPath     string      `json:"original_path,omitempty"` // client's path when -rewrite or -target-path changed it; Path is what the target got
+	TLSVersion       string      `json:"tls_version,omitempty"`   // negotiated with an HTTPS upstream
+	TLSCipher        string      `json:"tls_cipher,omitempty"`
+	ReqReplacements  *int        `json:"req_replacements,omitempty"` // -replace-req matches (0 = rule applied, nothing found)
+	RespReplacements *int        `json:"resp_replacements,omitempty"`
+	CORSPreflight    bool        `json:"cors_preflight,omitempty"`   // answered by -cors
+	Target           string      `json:"target,omitempty"`           // X-ProxyEye-Target backend used instead of the default
+	Attempts         int         `json:"attempts,omitempty"`         // upstream tries when -retries retried it
+	MirrorPair       uint64      `json:"mirror_pair,omitempty"`      // pairs a request with its -mirror copy (source "mirror")
+	RequestID        uint64      `json:"request_id,omitempty"`       // the id its request_started event had
+	Proxy            string      `json:"proxy,omitempty"`            // -proxy name ("default" for the main proxy once -proxy is used)
+	Tag              string      `json:"tag,omitempty"`              // from the client's X-ProxyEye-Tag header
+	StrippedHeaders  []string    `json:"stripped_headers,omitempty"` // removed before reaching the client
+	SetHeaders       []string    `json:"set_headers,omitempty"`      // overridden before reaching the client
+	Note             string      `json:"note,omitempty"`             // set by the user via PUT /history/{seq}/note
+	RespChunked      bool        `json:"resp_chunked,omitempty"`     // the target used Transfer-Encoding: chunked
+	RespTrailers     http.Header `json:"resp_trailers,omitempty"`    // sent after the body, e.g. gRPC's grpc-status
+	RespChunks       []chunkRead `json:"resp_chunks,omitempty"`      // body pieces as they arrived (-capture-chunks)
+	Hook             string      `json:"hook,omitempty"`             // what the -hook-url hook changed
+	HookError        string      `json:"hook_error,omitempty"`
+	ClientError      string      `json:"client_error,omitempty"` // writing the response to the client failed, e.g. it hung up
 }
 
 var (
@@ -71,12 +199,187 @@ var (
 	maxHistory   = 50
 )
 
+var (
+	// cliFormat selects the terminal output: "pretty" (default) or "tsv".
+	cliFormat = "pretty"
+	// noCLI (-no-cli, -quiet) runs headless: no terminal dashboard, only the
+	// web UI. Nothing reads cliChan then, so nothing is sent to it.
+	noCLI bool
+	// noDashboard (-no-dashboard) prints request lines without the logo,
+	// screen clear and pinned header, for logs shared with other processes.
+	noDashboard bool
+	// tuiMode (-tui) makes the terminal dashboard interactive.
+	tuiMode bool
+	// showErrorBody prints the response body under failed requests.
+	showErrorBody bool
+)
+
 func main() {
-	printLogo()
 	uiPort := flag.String("ui", "4040", "port for the inspector UI")
+	uiDirPtr := flag.String("ui-dir", "", "serve the inspector page and its assets (/inspect/...) from this directory instead of the built-in page")
+	uiBind := flag.String("ui-bind", "127.0.0.1", "address the inspector UI and proxy listen on, HOST or HOST:PORT (0.0.0.0 exposes them to the network)")
+	var proxyFlags stringList
+	flag.Var(&proxyFlags, "proxy", "run another named proxy: name=NAME,listen=[HOST:]PORT,target=URL|PORT (repeatable)")
+	proxyBind := flag.String("proxy-bind", "", "also serve proxied traffic only (no inspector) on this HOST:PORT")
 	portPtr := flag.String("p", "3000", "target port to proxy")
+	targetPathPtr := flag.String("target-path", "", "path prefix on the target, e.g. /api/v2 (a request for /users reaches /api/v2/users)")
+	mirrorPtr := flag.String("mirror", "", "also send a copy of each proxied request to this shadow backend (URL or port); the client gets the primary response")
 	domainPtr := flag.String("domain", "localhost", "custom domain name")
+	var delayFlags, delayPathFlags, failFlags stringList
+	flag.Var(&delayFlags, "delay", "inject latency: [METHOD ]PATH=DURATION[-DURATION]; PATH is an exact path unless it has regex syntax (repeatable)")
+	flag.Var(&delayPathFlags, "delay-path", "inject latency into one exact path, or a prefix ending in *: [METHOD ]PATH=DURATION[-DURATION] (repeatable)")
+	flag.Var(&failFlags, "fail", "inject faults: [METHOD ]PATH=PCT%:STATUS|ACTION, PATH as for -delay, ACTION one of abort, close_after_headers, close_after_n_bytes:N, garbage_response (repeatable)")
+	chaosSeedPtr := flag.Int64("chaos-seed", 0, "seed for chaos sampling (0 = random)")
+	maxBodyPtr := flag.Int64("max-body", 1<<20, "max body bytes captured per request; larger uploads are streamed")
+	compactPtr := flag.Bool("compact-bodies", false, "strip whitespace from JSON bodies before storing them in history")
+	flag.BoolVar(&headersOnly, "headers-only", false, "record only headers: bodies stream through without being captured or stored")
+	flag.BoolVar(&noDecode, "no-decode", false, "store gzip/deflate request bodies in history as sent, without decompressing them")
+	flag.BoolVar(&captureChunks, "capture-chunks", false, "record the size and arrival time of each piece of streamed response bodies")
+	var rateLimit rateLimitConfig
+	flag.StringVar(&rateLimit.Rate, "rate-limit", "", "per-client rate limit for proxied requests, e.g. 10rps or 600/m")
+	flag.StringVar(&apiRateLimit, "api-rate-limit", apiRateLimit, "per-client rate limit for the inspector's API (history, export, /api...), or off")
+	flag.IntVar(&rateLimit.Burst, "rate-limit-burst", 0, "rate limit burst size (default: one second's worth)")
+	flag.StringVar(&rateLimit.Key, "rate-limit-key", "ip", "rate limit client key: ip or header:Name")
+	flag.StringVar(&cliFormat, "cli-format", cliFormat, "terminal output format: pretty or tsv")
+	var cliFilterFlags stringList
+	flag.Var(&cliFilterFlags, "cli-filter", `only print requests matching all of these conditions, e.g. "status>=400 method=POST", path~REGEX, latency>200ms (repeatable)`)
+	flag.DurationVar(&cliFilterReport, "cli-filter-report", cliFilterReport, "how often to print how many requests -cli-filter hid (0 = never)")
+	flag.StringVar(&cliMode, "cli-mode", cliMode, "terminal output: stream (a line per request) or dashboard (the latest requests redrawn in place, with live stats)")
+	flag.BoolVar(&noCLI, "no-cli", false, "don't show requests in the terminal; use the web UI only")
+	flag.BoolVar(&noCLI, "quiet", false, "same as -no-cli: print only the startup URLs, errors and the exit summary")
+	flag.BoolVar(&noDashboard, "no-dashboard", false, "print request lines without clearing the screen or pinning a header, e.g. under docker-compose or foreman")
+	flag.BoolVar(&tuiMode, "tui", false, "interactive terminal dashboard: scroll back through requests and inspect their headers and bodies")
+	flag.BoolVar(&noWSCompression, "no-ws-compression", false, "don't compress websocket messages to the inspector (saves CPU, costs bandwidth)")
+	cliTemplatePtr := flag.String("cli-template", "", `text/template for each CLI line, e.g. "{{.Time}} {{.Status}} {{.Method}} {{.Path}} ({{.Latency}})"`)
+	throttlePtr := flag.String("throttle", "", "bandwidth limit for both directions, e.g. 256kbps")
+	throttleUpPtr := flag.String("throttle-up", "", "upload bandwidth limit (overrides -throttle)")
+	throttleDownPtr := flag.String("throttle-down", "", "download bandwidth limit (overrides -throttle)")
+	modePtr := flag.String("mode", "proxy", "proxy, or replay to answer from captured traffic")
+	replayFile := flag.String("replay-file", "", "JSON file of captured entries (e.g. saved /history) to replay from")
+	replayMatch := flag.String("replay-match", "method,path,query", "replay match components: method,path,query,body,header:Name")
+	replayFallthrough := flag.Bool("replay-fallthrough", false, "proxy unmatched requests in replay mode instead of answering 501")
+	forwardPtr := flag.Bool("forward", false, "also act as a forward proxy for clients using HTTP_PROXY and HTTPS_PROXY (HTTPS is tunneled, not inspected)")
+	flag.BoolVar(&noColor, "no-color", os.Getenv("NO_COLOR") != "", "disable colors in the terminal output (also set by NO_COLOR; off anyway when output isn't a terminal)")
+	flag.StringVar(&colorScheme, "color-scheme", colorScheme, "methods (color methods and statuses) or status (color statuses only)")
+	flag.Var((*stringList)(&showHeaders), "show-header", "append this request (or else response) header's value to each CLI line (repeatable)")
+	flag.BoolVar(&showErrorBody, "show-error-body", false, "print the (truncated) response body for 4xx/5xx responses in the CLI")
+	flag.StringVar(&hookURL, "hook-url", "", "webhook that may inspect and modify matching requests/responses")
+	flag.StringVar(&hookScript, "script", "", "Starlark file defining on_request(req) and/or on_response(req, resp), reloaded when it changes")
+	flag.StringVar(&notifyURL, "notify-url", "", "webhook to call when a -notify-* condition fires")
+	flag.StringVar(&notifyFormat, "notify-format", notifyFormat, "notification payload: json or slack")
+	flag.DurationVar(&notifyWindow, "notify-window", notifyWindow, "sliding window for -notify-error-rate/-notify-p95, and the debounce per condition")
+	flag.BoolVar(&notify5xx, "notify-5xx", notify5xx, "notify on any 5xx response")
+	flag.Float64Var(&notifyErrorRate, "notify-error-rate", 0, "notify when more than this percent of requests in the window are 5xx (0 = off)")
+	flag.DurationVar(&notifyP95, "notify-p95", 0, "notify when p95 latency in the window exceeds this, e.g. 500ms (0 = off)")
+	var notifyMatchFlags stringList
+	flag.Var(&notifyMatchFlags, "notify-match", "notify when a request matches [METHOD ]PATH (repeatable)")
+	var hookMatchFlags stringList
+	flag.Var(&hookMatchFlags, "hook-match", "only send requests matching [METHOD ]PATH to the hook (repeatable)")
+	flag.DurationVar(&hookTimeout, "hook-timeout", hookTimeout, "hook call timeout; on failure requests are forwarded unmodified")
+	var rewriteFlags stringList
+	flag.Var(&rewriteFlags, "rewrite", "rewrite proxied paths: [NAME: ]REGEX => REPLACEMENT, $1 for groups (repeatable, first match wins)")
+	flag.BoolVar(&rewriteLog, "rewrite-log", false, "record the applied -rewrite rule name on history entries")
+	var replayVarFlags stringList
+	flag.Var(&replayVarFlags, "replay-vars", "value for {{name}} placeholders in replayed requests: name=value (repeatable)")
+	var normalizeFlags stringList
+	flag.Var(&normalizeFlags, "normalize", `group paths in stats: REGEX => REPLACEMENT, e.g. "/\d+ => /:id" (repeatable, applied in order)`)
+	var setQueryFlags stringList
+	flag.Var(&setQueryFlags, "set-query", "add or override a query parameter on proxied requests: key=value (repeatable)")
+	flag.Var((*stringList)(&removeQuery), "remove-query", "drop a query parameter from proxied requests (repeatable)")
+	var replaceReqFlags, replaceRespFlags stringList
+	flag.Var(&replaceReqFlags, "replace-req", "replace text in request bodies: [re:]PATTERN=>REPLACEMENT[ type=CONTENT-TYPE] (repeatable)")
+	flag.Var(&replaceRespFlags, "replace-resp", "replace text in response bodies: [re:]PATTERN=>REPLACEMENT[ type=CONTENT-TYPE] (repeatable)")
+	flag.BoolVar(&corsEnabled, "cors", false, "answer CORS preflights and allow the request's origin on every response")
+	flag.BoolVar(&corsOverride, "cors-override", false, "with -cors, replace CORS headers the target already set")
+	var setRespHeaderFlags stringList
+	flag.Var((*stringList)(&stripRespHeaders), "strip-resp-header", "remove this header from responses sent to the client (repeatable)")
+	flag.Var(&setRespHeaderFlags, "set-resp-header", "set a response header sent to the client: \"Name: value\" (repeatable)")
+	cacheModePtr := flag.Bool("cache-mode", false, "serve repeated requests (method, path, query) from the first response the target gave")
+	cacheOnlyPtr := flag.Bool("cache-only", false, "serve only from the response cache and answer 504 on a miss")
+	flag.StringVar(&fixturesDir, "fixtures", "", "answer requests from recorded fixtures in this directory, recording new ones from the target on a miss")
+	diffHeadersPtr := flag.String("diff-ignore-headers", strings.Join(diffIgnoreHeaders, ","), "response headers left out of replay diffs")
+	diffFieldsPtr := flag.String("diff-ignore-fields", strings.Join(diffIgnoreFields, ","), "JSON field names left out of replay diffs, at any depth")
+	var captureFlags, ignoreFlags stringList
+	flag.Var(&captureFlags, "capture-only", "only record requests matching [METHOD ]PATH[ type=CONTENT-TYPE] (repeatable)")
+	flag.Var(&ignoreFlags, "ignore", "never record requests matching [METHOD ]PATH[ type=CONTENT-TYPE] (repeatable, wins over -capture-only)")
+	flag.BoolVar(&readOnly, "read-only", false, "reject all mutating inspector endpoints with 403 (safe for sharing)")
+	minTLSPtr := flag.String("min-tls", "", "minimum TLS version for HTTPS backends: 1.0, 1.1, 1.2 or 1.3")
+	flag.IntVar(&retries, "retries", 0, "retry GET/HEAD/OPTIONS requests up to N times on connection errors and -retry-status responses")
+	flag.DurationVar(&retryBackoff, "retry-backoff", retryBackoff, "wait before the first retry; doubles with each attempt")
+	retryStatusPtr := flag.String("retry-status", "502,503,504", "response statuses that trigger a retry (comma-separated)")
+	insecurePtr := flag.Bool("insecure-skip-verify", false, "don't verify HTTPS backend certificates (self-signed dev servers)")
+	flag.BoolVar(&debugEnabled, "debug", false, "serve pprof and expvar under /debug on the inspector port")
+	flag.BoolVar(&allowDynamicTarget, "allow-dynamic-target", false, "let requests choose their backend with an X-ProxyEye-Target: URL header")
+	var apiCORSFlags stringList
+	flag.Var(&apiCORSFlags, "api-cors-origin", "allow this browser origin to call the inspector APIs and /ws, e.g. http://localhost:5173 (repeatable or comma-separated, * for any)")
+	flag.StringVar(&authToken, "token", "", `require this token on the inspector UI and APIs ("auto" generates one)`)
+	versionPtr := flag.Bool("version", false, "print the version and exit")
+	flushPtr := flag.String("flush-interval", "0", "how often to flush proxied responses to the client, e.g. 100ms (-1 = immediately)")
+	flag.StringVar(&saveFile, "save", "", "write history as JSON to this file on shutdown (loadable with -replay-file)")
+	snapshotPtr := flag.Duration("snapshot-interval", 0, "with -save, also write history every interval, e.g. 30s")
+	accessLogPtr := flag.String("access-log", "", "append a line per proxied request to this file (- for stdout)")
+	accessLogFormat := flag.String("access-log-format", "clf", "-access-log format: clf (Common Log Format) or json (JSON lines)")
+	accessLogMaxSize := flag.Int64("access-log-max-size", 0, "rotate -access-log when it reaches this many bytes (0 = never)")
+	accessLogKeep := flag.Int("access-log-keep", 5, "rotated -access-log files to keep")
+	printJSON := flag.Bool("print-json", false, "print a JSON startup handshake line instead of the banner")
+	dumpConfigPtr := flag.Bool("dump-config", false, "print the flags that differ from their defaults, as JSON and as a command line, and exit")
 	flag.Parse()
+	if *versionPtr {
+		fmt.Println(buildVersion())
+		return
+	}
+	if *dumpConfigPtr {
+		out, _ := json.MarshalIndent(dumpConfig(false), "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	maxBody.Store(*maxBodyPtr)
+	compactBodies.Store(*compactPtr)
+	apiCORSOrigins = parseOrigins(apiCORSFlags)
+	retryStatus = nil
+	for _, s := range strings.Split(*retryStatusPtr, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 100 || code > 599 {
+			log.Fatalf("-retry-status: invalid status %q", s)
+		}
+		retryStatus = append(retryStatus, code)
+	}
+	if *cliTemplatePtr != "" {
+		t, err := parseCLITemplate(*cliTemplatePtr)
+		if err != nil {
+			log.Fatalf("-cli-template: %v", err)
+		}
+		cliTemplate = t
+	}
+	if cliFormat != "pretty" && cliFormat != "tsv" {
+		log.Fatalf("-cli-format: unknown format %q (want pretty or tsv)", cliFormat)
+	}
+	for _, spec := range cliFilterFlags {
+		conds, err := parseCLIFilter(spec)
+		if err != nil {
+			log.Fatalf("-cli-filter: %v", err)
+		}
+		cliFilters = append(cliFilters, conds...)
+	}
+	if colorScheme != "methods" && colorScheme != "status" {
+		log.Fatalf("-color-scheme: unknown scheme %q (want methods or status)", colorScheme)
+	}
+	setupANSI()
+	if cliMode != "stream" && cliMode != "dashboard" {
+		log.Fatalf("-cli-mode: unknown mode %q (want stream or dashboard)", cliMode)
+	}
+	if tuiMode && (noCLI || noDashboard || cliFormat != "pretty" || cliMode != "stream") {
+		log.Fatal("-tui can't be combined with -no-cli, -no-dashboard, -cli-format tsv or -cli-mode dashboard")
+	}
+	if cliMode == "dashboard" && (noCLI || noDashboard || cliFormat != "pretty") {
+		log.Fatal("-cli-mode dashboard can't be combined with -no-cli, -no-dashboard or -cli-format tsv")
+	}
+	if !*printJSON && cliFormat == "pretty" && !noCLI && !noDashboard {
+		printLogo()
+	}
 	// Get the port from the argument if provided (e.g., ./proxyeye 8080)
 	targetPort := *portPtr
 	customDomain := *domainPtr
@@ -87,163 +390,1053 @@ func main() {
 
 	// 2. Build the target URL dynamically
 	targetURL := fmt.Sprintf("http://127.0.0.1:%s", targetPort)
-	uiAddr := ":" + *uiPort
+	exportBaseURL = targetURL
+	uiAddr := listenAddr(*uiBind, *uiPort)
+	uiHost, uiPortNum, _ := net.SplitHostPort(uiAddr)
 	target, err := url.Parse(targetURL)
 	if err != nil {
 		log.Fatal("Invalid target port")
 	}
+	if *targetPathPtr != "" {
+		target.Path = "/" + strings.Trim(*targetPathPtr, "/")
+		targetURL = target.String()
+	}
+	if err := checkPorts(uiPortNum, targetPort); err != nil {
+		log.Fatal(err)
+	}
+	upgrader.EnableCompression = !noWSCompression
+	if *uiDirPtr != "" {
+		if err := setUIDir(*uiDirPtr); err != nil {
+			log.Fatalf("-ui-dir: %v", err)
+		}
+	}
+	if *mirrorPtr != "" {
+		if err := setMirror(*mirrorPtr); err != nil {
+			log.Fatalf("-mirror: %v", err)
+		}
+	}
 	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Director = proxyDirector(proxy.Director)
+	proxy.Transport, proxy.ErrorHandler = proxyTransport, proxyErrorHandler
+	if err := configureUpstreamTLS(*minTLSPtr, *insecurePtr); err != nil {
+		log.Fatalf("-min-tls: %v", err)
+	}
+	if *insecurePtr {
+		log.Printf("WARNING: -insecure-skip-verify: HTTPS backend certificates are NOT verified; use only with trusted dev servers")
+	}
+
+	var rules []*chaosRule
+	for _, spec := range delayFlags {
+		rule, err := parseDelayFlag(spec)
+		if err != nil {
+			log.Fatalf("-delay: %v", err)
+		}
+		rules = append(rules, rule)
+	}
+	for _, spec := range delayPathFlags {
+		rule, err := parseDelayPathFlag(spec)
+		if err != nil {
+			log.Fatalf("-delay-path: %v", err)
+		}
+		rules = append(rules, rule)
+	}
+	for _, spec := range failFlags {
+		rule, err := parseFailFlag(spec)
+		if err != nil {
+			log.Fatalf("-fail: %v", err)
+		}
+		rules = append(rules, rule)
+	}
+	setChaos(chaosConfig{Seed: *chaosSeedPtr, Rules: rules})
+	if err := limiter.configure(rateLimit); err != nil {
+		log.Fatalf("-rate-limit: %v", err)
+	}
+	if apiRateLimit == "off" {
+		apiRateLimit = ""
+	}
+	if err := apiLimiter.configure(rateLimitConfig{Rate: apiRateLimit}); err != nil {
+		log.Fatalf("-api-rate-limit: %v", err)
+	}
+	for _, f := range []struct {
+		name  string
+		specs stringList
+		dst   *[]*captureRule
+	}{
+		{"capture-only", captureFlags, &captureRules},
+		{"ignore", ignoreFlags, &ignoreRules},
+	} {
+		for _, spec := range f.specs {
+			rule, err := parseCaptureRule(spec)
+			if err != nil {
+				log.Fatalf("-%s: %v", f.name, err)
+			}
+			*f.dst = append(*f.dst, rule)
+		}
+	}
+	for _, spec := range normalizeFlags {
+		n, err := parseNormalize(spec)
+		if err != nil {
+			log.Fatalf("-normalize: %v", err)
+		}
+		pathNormalizers = append(pathNormalizers, n)
+	}
+	for _, spec := range replayVarFlags {
+		k, v, ok := strings.Cut(spec, "=")
+		if !ok || k == "" {
+			log.Fatalf("-replay-vars: expected name=value, got %q", spec)
+		}
+		replayVars[k] = v
+	}
+	for _, spec := range setQueryFlags {
+		k, v, ok := strings.Cut(spec, "=")
+		if !ok || k == "" {
+			log.Fatalf("-set-query: expected key=value, got %q", spec)
+		}
+		setQuery.Add(k, v)
+	}
+	for i, spec := range rewriteFlags {
+		rule, err := parseRewriteRule(spec, i+1)
+		if err != nil {
+			log.Fatalf("-rewrite: %v", err)
+		}
+		rewriteRules = append(rewriteRules, rule)
+	}
+	for _, f := range []struct {
+		name  string
+		specs stringList
+		dst   *[]*replaceRule
+	}{
+		{"replace-req", replaceReqFlags, &reqReplaceRules},
+		{"replace-resp", replaceRespFlags, &respReplaceRules},
+	} {
+		for _, spec := range f.specs {
+			rule, err := parseReplaceRule(spec)
+			if err != nil {
+				log.Fatalf("-%s: %v", f.name, err)
+			}
+			*f.dst = append(*f.dst, rule)
+		}
+	}
+	for _, spec := range setRespHeaderFlags {
+		name, value, err := parseHeaderLine(spec)
+		if err != nil {
+			log.Fatalf("-set-resp-header: %v", err)
+		}
+		setRespHeaders.Add(name, value)
+	}
+	diffIgnoreHeaders = splitList(*diffHeadersPtr)
+	diffIgnoreFields = splitList(*diffFieldsPtr)
+	switch {
+	case *cacheOnlyPtr:
+		cacheState = "only"
+	case *cacheModePtr:
+		cacheState = "cache"
+	}
+	if fixturesDir != "" {
+		if err := os.MkdirAll(fixturesDir, 0o755); err != nil {
+			log.Fatalf("-fixtures: %v", err)
+		}
+	}
+	if hookScript != "" {
+		if hookURL != "" {
+			log.Fatal("-script and -hook-url are mutually exclusive")
+		}
+		if _, err := loadScript(); err != nil {
+			log.Fatalf("-script: %v", err)
+		}
+	}
+	for _, spec := range hookMatchFlags {
+		m := parseRoute(spec)
+		if err := m.compile(); err != nil {
+			log.Fatalf("-hook-match: %v", err)
+		}
+		hookRoutes = append(hookRoutes, m)
+	}
+	if notifyURL != "" {
+		if err := startNotifier(notifyMatchFlags); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := setReplayConfig(replayConfig{
+		Mode:        *modePtr,
+		Match:       strings.Split(*replayMatch, ","),
+		Fallthrough: *replayFallthrough,
+	}); err != nil {
+		log.Fatalf("-mode: %v", err)
+	}
+	if *replayFile != "" {
+		if err := loadReplaySession(*replayFile); err != nil {
+			log.Fatalf("-replay-file: %v", err)
+		}
+	}
+	for _, t := range []struct {
+		name, value string
+		dst         []*float64
+	}{
+		{"throttle", *throttlePtr, []*float64{&throttleUp, &throttleDown}},
+		{"throttle-up", *throttleUpPtr, []*float64{&throttleUp}},
+		{"throttle-down", *throttleDownPtr, []*float64{&throttleDown}},
+	} {
+		if t.value == "" {
+			continue
+		}
+		bps, err := parseBandwidth(t.value)
+		if err != nil {
+			log.Fatalf("-%s: %v", t.name, err)
+		}
+		for _, d := range t.dst {
+			*d = bps
+		}
+	}
 
 	// Intercept the Response
-	proxy.ModifyResponse = func(r *http.Response) error {
-		// 1. Capture the headers IMMEDIATELY
-		// We clone them because the proxy might mutate 'r' later
-		capturedHeaders := make(http.Header)
-		for k, v := range r.Header {
-			capturedHeaders[k] = v
-		}
-
-		dump, _ := httputil.DumpResponse(r, false)
-		dumpRequest, _ := httputil.DumpRequest(r.Request, false)
-		// 2. Standard body processing
-		resBody, _ := io.ReadAll(r.Body)
-		r.Body = io.NopCloser(bytes.NewBuffer(resBody)) // Reset for client
-
-		var latency string
-		if startTime, ok := r.Request.Context().Value(startTimeKey).(time.Time); ok {
-			// Convert to milliseconds and format to 2 decimal places
-			ms := float64(time.Since(startTime)) / 1e6
-			latency = fmt.Sprintf("%.2fms", ms)
-		}
-		ctx := r.Request.Context()
-		reqBody, _ := ctx.Value("capturedReqBody").(string)
-
-		broadcast <- CombinedLog{
-			Method:      r.Request.Method,
-			Path:        r.Request.URL.Path,
-			QueryString: r.Request.URL.RawQuery,
-			ReqHeaders:  string(dumpRequest),
-			Status:      r.StatusCode,
-			ReqBody:     reqBody,
-			RespHeaders: string(dump),
-			RespBody:    string(resBody),
-			Latency:     latency,
-			Time:        time.Now().Format("15:04:05"),
+	proxy.ModifyResponse = modifyResponse
+	flushInterval := time.Duration(-1)
+	if *flushPtr != "-1" {
+		if flushInterval, err = time.ParseDuration(*flushPtr); err != nil {
+			log.Fatalf("-flush-interval: %v", err)
 		}
-		return nil
+	}
+	proxy.FlushInterval = flushInterval
+	forwardProxy.FlushInterval = flushInterval
+	if err := startNamedProxies(proxyFlags, uiHost, proxy); err != nil {
+		log.Fatal(err)
 	}
 
+	mux := http.NewServeMux()
+
 	// 1. WebSocket Route
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		ws, _ := upgrader.Upgrade(w, r, nil)
-		clientsMu.Lock()
-		clients[ws] = true
-		clientsMu.Unlock()
-	})
+	mux.HandleFunc("/ws", handleWS)
 
 	// 2. Proxy + Request Timer
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/ws" ||
 			r.URL.Path == "/inspect" ||
 			r.URL.Path == "/favicon.ico" ||
 			strings.Contains(r.URL.Path, ".well-known") {
 			return
 		}
-		// Inject start time into context
-		// --- Intercept Request Body ---
-		var reqBodyBytes []byte
-		if r.Body != nil {
-			reqBodyBytes, _ = io.ReadAll(r.Body)
-		}
-		// Restore the body so the proxy can still send it to the target
-		r.Body = io.NopCloser(bytes.NewBuffer(reqBodyBytes))
-
-		ctx := r.Context()
-		start := time.Now()
-		ctx = context.WithValue(r.Context(), startTimeKey, start)
-		ctx = context.WithValue(ctx, "capturedReqBody", string(reqBodyBytes))
-		r = r.WithContext(ctx)
-		proxy.ServeHTTP(w, r)
+		serveProxied(w, r, proxy)
 	})
 
-	http.HandleFunc("/inspect", func(w http.ResponseWriter, r *http.Request) {
-		data, _ := staticFiles.ReadFile("index.html")
-		w.Header().Set("Content-Type", "text/html")
-		w.Write(data)
-	})
+	mux.HandleFunc("/inspect", handleInspect)
+	if uiRoot != nil {
+		mux.HandleFunc("GET /inspect/{file...}", handleUIAsset)
+	}
+
+	mux.HandleFunc("/api/chaos", guardWrites(handleChaosAPI))
+	mux.HandleFunc("/api/ratelimit", guardWrites(handleRateLimitAPI))
+	mux.HandleFunc("/api/mode", guardWrites(handleModeAPI))
+	mux.HandleFunc("/api/stats", handleStatsAPI)
+	mux.HandleFunc("GET /api/status", handleStatusAPI)
+	mux.HandleFunc("GET /metrics", handleMetrics)
+	mux.HandleFunc("GET /stats/timeline", handleTimeline)
+	mux.HandleFunc("GET /stream", handleStream)
+	mux.HandleFunc("GET /poll", handlePoll)
+	mux.HandleFunc("GET /__proxyeye/version", handleVersion)
+	mux.HandleFunc("GET /__proxyeye/config", handleConfigDump)
+	mux.HandleFunc("POST /api/capture/{action}", guardWrites(handleCapturePause))
+	mux.HandleFunc("/api/cache", guardWrites(handleCacheAPI))
+	mux.HandleFunc("/api/ignores", guardWrites(handleIgnoresAPI))
+	mux.HandleFunc("GET /api/config", handleConfigAPI)
+	mux.HandleFunc("PUT /api/config", guardWrites(handleConfigAPI))
+	mux.HandleFunc("GET /history/{seq}", handleHistoryEntry)
+	mux.HandleFunc("GET /history/{seq}/body", limitExports(handleBodyDownload))
+	mux.HandleFunc("GET /export/postman", limitExports(handleExportPostman))
+	mux.HandleFunc("PUT /history/{seq}/note", guardWrites(handleNote))
+	mux.HandleFunc("POST /replay/{seq}", guardWrites(handleReplay))
+	mux.HandleFunc("POST /api/replay", guardWrites(handleReplayRun))
+	mux.HandleFunc("POST /api/replay/session", guardWrites(handlePlaybackStart))
+	mux.HandleFunc("GET /api/replay/session/{id}", handlePlaybackControl)
+	mux.HandleFunc("POST /api/replay/session/{id}/{action}", guardWrites(handlePlaybackControl))
+	mux.HandleFunc("/api/probes", guardWrites(handleProbesAPI))
+	mux.HandleFunc("DELETE /api/probes/{id}", guardWrites(handleProbeDelete))
+	mux.HandleFunc("GET /api/clients", handleClients)
+	mux.HandleFunc("DELETE /api/clients/{id}", guardWrites(handleDisconnectClient))
+	mux.HandleFunc("/api/share", guardWrites(handleShareAPI))
+	mux.HandleFunc("GET /api/share/{id}", handleShare)
+	mux.HandleFunc("DELETE /api/share/{id}", guardWrites(handleShare))
 
-	http.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("DELETE /history", guardWrites(func(w http.ResponseWriter, r *http.Request) {
 		historyMutex.Lock()
-		defer historyMutex.Unlock()
+		cleared := historySeqs(history)
+		history = nil
+		historyMutex.Unlock()
+		broadcastEvent(map[string]any{"type": "history_cleared", "seqs": cleared})
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mux.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+		// Encode a copy: a slow client must not hold up capture.
+		historyMutex.Lock()
+		all := slices.Clone(history)
+		historyMutex.Unlock()
 
+		q := r.URL.Query()
+		entries := all
+		scope := requestShare(r).filter()
+		if tag, name := q.Get("tag"), q.Get("proxy"); tag != "" || name != "" || scope != nil {
+			entries = []CombinedLog{}
+			for _, e := range all {
+				if (tag == "" || e.Tag == tag) && (name == "" || e.Proxy == name) && scope.matches(&e) {
+					entries = append(entries, e)
+				}
+			}
+		}
+		// ?limit=N&offset=M pages back from the newest entry, keeping
+		// oldest-first order within the page.
+		total := len(entries)
+		if q.Has("limit") || q.Has("offset") {
+			limit, offset := total, 0
+			var err error
+			if q.Has("limit") {
+				if limit, err = strconv.Atoi(q.Get("limit")); err != nil || limit < 0 {
+					http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+					return
+				}
+			}
+			if q.Has("offset") {
+				if offset, err = strconv.Atoi(q.Get("offset")); err != nil || offset < 0 {
+					http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+					return
+				}
+			}
+			end := max(total-offset, 0)
+			entries = entries[max(end-limit, 0):end]
+		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(history)
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		json.NewEncoder(w).Encode(entries)
 	})
 
-	go handleBroadcasts()                                     // For Web UI
-	go startCLIDashboard(targetPort, targetURL, customDomain) // For Terminal UI
+	// Bind before announcing anything so the printed ports are real
+	// (e.g. "-ui 0" picks a free port).
+	ln, err := net.Listen("tcp", uiAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	boundPort := ln.Addr().(*net.TCPAddr).Port
+	if err := checkPorts(strconv.Itoa(boundPort), targetPort); err != nil {
+		log.Fatal(err) // "-ui 0" landed on the target port
+	}
+	selfURL = reachableURL(uiHost, boundPort)
+
+	// The proxy-only listener never serves the inspector, so it can be
+	// shared more widely than -ui-bind.
+	var proxyLn net.Listener
+	if *proxyBind != "" {
+		if proxyLn, err = net.Listen("tcp", *proxyBind); err != nil {
+			log.Fatal(err)
+		}
+		if err := checkPorts(strconv.Itoa(proxyLn.Addr().(*net.TCPAddr).Port), targetPort); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if authToken == "auto" {
+		authToken = generateToken()
+	}
+	fixedConfig = map[string]any{
+		"ui":                   ln.Addr().String(),
+		"proxy_bind":           *proxyBind,
+		"proxies":              namedProxies,
+		"target":               targetURL,
+		"mirror":               *mirrorPtr,
+		"forward":              *forwardPtr,
+		"allow_dynamic_target": allowDynamicTarget,
+		"read_only":            readOnly,
+		"token_required":       authToken != "",
+		"api_cors_origin":      apiCORSOrigins,
+		"debug":                debugEnabled,
+		"headers_only":         headersOnly,
+		"save":                 saveFile,
+	}
+	if !isLocalHost(uiHost) && authToken == "" {
+		log.Printf("WARNING: the inspector is reachable from the network (-ui-bind %s) without -token; anyone who can connect can read captured traffic", *uiBind)
+	}
+	proxyURL := displayURL(uiHost, boundPort)
+	notifyBaseURL = proxyURL
+	inspectURL := proxyURL + "/inspect"
+	if authToken != "" {
+		inspectURL += "?token=" + url.QueryEscape(authToken)
+	}
+	if *printJSON {
+		out := map[string]any{
+			"ui":     inspectURL,
+			"proxy":  proxyURL,
+			"target": targetURL,
+		}
+		if len(namedProxies) > 0 {
+			proxies := map[string]map[string]string{}
+			for _, p := range namedProxies {
+				proxies[p.Name] = map[string]string{"listen": listenerURL(p.ln), "target": p.Target}
+			}
+			out["proxies"] = proxies
+		}
+		if authToken != "" {
+			out["token"] = authToken
+		}
+		if proxyLn != nil {
+			out["proxy_only"] = listenerURL(proxyLn)
+		}
+		json.NewEncoder(os.Stdout).Encode(out)
+	} else if authToken != "" && cliFormat != "pretty" && !noCLI {
+		log.Printf("inspector: %s", inspectURL) // keep stdout to the TSV rows
+	}
 
-	fmt.Printf("🚀 ProxyEye: http://localhost:%s/inspect\n", *uiPort)
-	fmt.Printf("🚀 Proxying: http://localhost:%s -> %s\n", *uiPort, targetURL)
-	log.Fatal(http.ListenAndServe(uiAddr, nil))
+	if *accessLogPtr != "" {
+		l, err := openAccessLog(*accessLogPtr, *accessLogFormat, *accessLogMaxSize, *accessLogKeep)
+		if err != nil {
+			log.Fatalf("-access-log: %v", err)
+		}
+		accessLog = l
+	}
+	if *snapshotPtr > 0 {
+		if saveFile == "" {
+			log.Fatal("-snapshot-interval requires -save")
+		}
+		startSnapshots(*snapshotPtr)
+	}
+	listenAddrs = map[string]string{"ui": listenerURL(ln), "proxy": proxyURL}
+	if proxyLn != nil {
+		listenAddrs["proxy_only"] = listenerURL(proxyLn)
+	}
+	startHealthChecks(target)
+	go handleBroadcasts() // For Web UI
+	watchShutdown()
+	if tuiMode {
+		go startTUI(targetPort, targetURL, customDomain)
+	} else if cliMode == "dashboard" {
+		go startCompactDashboard(targetPort, targetURL, customDomain)
+	} else if !noCLI {
+		go startCLIDashboard(targetPort, targetURL, customDomain) // For Terminal UI
+	}
+
+	if !*printJSON && (cliFormat == "pretty" || noCLI) {
+		fmt.Printf("🚀 ProxyEye: %s\n", inspectURL)
+		fmt.Printf("🚀 Proxying: %s -> %s\n", proxyURL, targetURL)
+		if proxyLn != nil {
+			fmt.Printf("🚀 Proxying: %s -> %s (proxy only)\n", listenerURL(proxyLn), targetURL)
+		}
+		for _, p := range namedProxies {
+			fmt.Printf("🚀 Proxying: %s -> %s (%s)\n", listenerURL(p.ln), p.Target, p.Name)
+		}
+		if mirrorURL != nil {
+			fmt.Printf("🚀 Mirroring: %s -> %s\n", proxyURL, mirrorURL)
+		}
+	}
+	if debugEnabled {
+		registerDebug(mux)
+	}
+	handler := withAPICORS(mux, withInspectorLimits(mux, withAuth(mux)))
+	if *forwardPtr {
+		handler = withForwardProxy(handler)
+	}
+	if proxyLn != nil {
+		var proxyOnly http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serveProxied(w, r, proxy)
+		})
+		if *forwardPtr {
+			proxyOnly = withForwardProxy(proxyOnly)
+		}
+		go serve(proxyLn, proxyOnly)
+	}
+	serverState.Store(stateReady)
+	serve(ln, handler)
+}
+
+// serveProxied runs a proxied request through the capture pipeline (body
+// capture, rate limiting, chaos, throttling, replay) before handing it to
+// upstream.
+func serveProxied(w http.ResponseWriter, r *http.Request, upstream http.Handler) {
+	r, info, reqBody := withCaptureContext(r)
+	w = &clientErrWriter{w, info}
+	defer startPending(r, info)()
+	defer reportClientError(info)
+	if info.target != "" {
+		p, err := dynamicProxy(upstream, info.target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		upstream = p
+	}
+	if rejectLoop(w, r) {
+		return
+	}
+	if applyRateLimit(w, r, info) {
+		return
+	}
+	if applyCORSPreflight(w, r, info) {
+		return
+	}
+	w, handled := applyChaos(w, r, info)
+	if handled {
+		return
+	}
+	if info.finish != nil {
+		defer info.finish() // even when the proxy aborts the handler
+	}
+	w, err := applyThrottle(w, r, info)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if applyRequestHook(w, r, info, reqBody) {
+		return
+	}
+	if serveReplay(w, r, info, reqBody) {
+		return
+	}
+	if serveFixture(w, r, info) {
+		return
+	}
+	if serveCache(w, r, info) {
+		return
+	}
+	startMirror(r, info)
+	upstream.ServeHTTP(w, r)
+}
+
+// tagHeader lets clients label their requests, e.g. with the name of the
+// test that sent them; it is stripped before forwarding.
+const tagHeader = "X-ProxyEye-Tag"
+
+// withCaptureContext attaches the state captureResponse reads back when it
+// builds the entry: start time, request body capture and annotations.
+func withCaptureContext(r *http.Request) (*http.Request, *requestInfo, *bodyCapture) {
+	info := &requestInfo{originalPath: r.URL.Path, proxy: requestProxyName(r)}
+	if r.Header.Get(replayHeader) != "" {
+		info.replayed = true
+		r.Header.Del(replayHeader)
+	}
+	if v := r.Header.Get(tagHeader); v != "" {
+		info.tag = v
+		r.Header.Del(tagHeader)
+	}
+	if v := r.Header.Get(targetHeader); v != "" && allowDynamicTarget {
+		info.target = v
+		r.Header.Del(targetHeader)
+	}
+	if v := r.Header.Get(probeHeader); v != "" {
+		info.probe, _ = strconv.Atoi(v)
+		r.Header.Del(probeHeader)
+	}
+	capture, typeRe := captureDecision(r)
+	info.skip, info.captureType = !capture, typeRe
+	applyRequestReplace(r, info)
+
+	// Inject start time into context
+	// --- Intercept Request Body ---
+	reqBody := &bodyCapture{}
+	if capture && !headersOnly || hookMatches(r) {
+		reqBody = captureRequestBody(r)
+	}
+
+	ctx := r.Context()
+	start := time.Now()
+	if r.URL.IsAbs() || r.Method == http.MethodConnect {
+		info.host = r.Host
+	}
+	ctx = context.WithValue(r.Context(), startTimeKey, start)
+	ctx = context.WithValue(ctx, reqBodyKey, reqBody)
+	ctx = context.WithValue(ctx, reqInfoKey, info)
+	return r.WithContext(ctx), info, reqBody
+}
+
+// captureResponse is the proxy's ModifyResponse hook: it snapshots the
+// request/response pair into a CombinedLog and hands it to the broadcaster.
+func captureResponse(r *http.Response) error {
+	info, _ := r.Request.Context().Value(reqInfoKey).(*requestInfo)
+	if info != nil && !info.recordable(r) {
+		skippedCount.Add(1)
+		// Still counted in /metrics, timed to the response headers and
+		// with the sizes the headers declare: the bodies aren't read.
+		start, _ := r.Request.Context().Value(startTimeKey).(time.Time)
+		observeRequest(r.Request.Method, r.StatusCode, time.Since(start), max(r.Request.ContentLength, 0), max(r.ContentLength, 0))
+		if info.hooked {
+			resBody, _ := io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewBuffer(resBody))
+			applyResponseHook(r, resBody, info)
+		}
+		return nil
+	}
+
+	// 1. Capture the headers IMMEDIATELY
+	// We clone them because the proxy might mutate 'r' later
+	capturedHeaders := make(http.Header)
+	for k, v := range r.Header {
+		capturedHeaders[k] = v
+	}
+
+	dump, _ := httputil.DumpResponse(r, false)
+	dumpRequest, _ := httputil.DumpRequest(r.Request, false)
+
+	if headersOnly && (info == nil || !info.hooked) {
+		recordEntry(r, string(dump), string(dumpRequest), "", false, max(r.ContentLength, 0), info)
+		return nil
+	}
+
+	// Streams, and bodies longer than -max-body, are teed while the proxy
+	// copies them (it flushes streams immediately), keeping only a
+	// preview; the entry is emitted once the body ends.
+	limit := maxBody.Load()
+	if (isStreaming(r) || r.ContentLength > limit) && (info == nil || !info.hooked) && r.Body != http.NoBody {
+		c := &bodyCapture{max: limit}
+		if captureChunks && info != nil {
+			c.start = time.Now()
+		}
+		r.Body = &streamCapture{ReadCloser: r.Body, c: c, done: func() {
+			body, truncated := c.snapshot()
+			if info != nil {
+				info.respChunks = c.chunkReads()
+			}
+			recordEntry(r, string(dump), string(dumpRequest), body, truncated, c.size(), info)
+		}}
+		return nil
+	}
+
+	// 2. Standard body processing. A hook gets the whole body; history
+	// keeps the first -max-body bytes of it.
+	resBody, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewBuffer(resBody)) // Reset for client
+	if info != nil && info.hooked {
+		applyResponseHook(r, resBody, info)
+	}
+	c := &bodyCapture{max: limit}
+	c.Write(resBody)
+	body, truncated := c.snapshot()
+	recordEntry(r, string(dump), string(dumpRequest), body, truncated, c.size(), info)
+	return nil
+}
+
+// recordEntry builds the CombinedLog for a finished exchange and hands it to
+// the broadcaster.
+func recordEntry(r *http.Response, dump, dumpRequest, resBody string, respTruncated bool, respBytes int64, info *requestInfo) {
+	var latency string
+	var elapsed time.Duration
+	startTime, ok := r.Request.Context().Value(startTimeKey).(time.Time)
+	if ok {
+		elapsed = time.Since(startTime)
+		// Convert to milliseconds and format to 2 decimal places
+		ms := float64(elapsed) / 1e6
+		latency = fmt.Sprintf("%.2fms", ms)
+	}
+	ctx := r.Request.Context()
+	var reqBody string
+	var reqTruncated bool
+	reqBytes := max(r.Request.ContentLength, 0) // when the body wasn't read
+	if c, ok := ctx.Value(reqBodyKey).(*bodyCapture); ok {
+		reqBody, reqTruncated = c.snapshot()
+		reqBytes = max(reqBytes, c.size())
+	}
+	if headersOnly { // a hook may have read the bodies; they still aren't kept
+		reqBody, reqTruncated, resBody, respTruncated = "", false, "", false
+	}
+
+	observeRequest(r.Request.Method, r.StatusCode, elapsed, reqBytes, respBytes)
+
+	// A truncated body can't be decompressed; it stays as sent.
+	var reqDecoded string
+	if enc := r.Request.Header.Get("Content-Encoding"); enc != "" && !noDecode && !reqTruncated {
+		if plain, ok := decodeContent(enc, []byte(reqBody)); ok {
+			reqBody, reqDecoded = string(plain), strings.ToLower(enc)
+		}
+	}
+
+	if compactBodies.Load() {
+		if !reqTruncated {
+			reqBody = compactJSON(reqBody)
+		}
+		if !respTruncated {
+			resBody = compactJSON(resBody)
+		}
+	}
+	reqBody, reqEncoding := encodeBody(reqBody)
+	resBody, respEncoding := encodeBody(resBody)
+
+	now := time.Now()
+	entry := CombinedLog{
+		Method:           r.Request.Method,
+		Path:             r.Request.URL.Path,
+		QueryString:      r.Request.URL.RawQuery,
+		ReqHeaders:       dumpRequest,
+		Status:           r.StatusCode,
+		StatusText:       http.StatusText(r.StatusCode),
+		ReqBody:          reqBody,
+		ReqTruncated:     reqTruncated,
+		ReqBodyEncoding:  reqEncoding,
+		ReqDecoded:       reqDecoded,
+		RespHeaders:      dump,
+		RespBody:         resBody,
+		RespTruncated:    respTruncated,
+		RespBodyEncoding: respEncoding,
+		RespChunked:      slices.Contains(r.TransferEncoding, "chunked"),
+		RespTrailers:     sentTrailers(r.Trailer),
+		Client:           r.Request.RemoteAddr,
+		Latency:          latency,
+		Time:             now.Format("15:04:05.000"),
+		TimeISO:          now.Format("2006-01-02T15:04:05.000Z07:00"),
+		EndTime:          now.UnixMilli(),
+		ReqBytes:         reqBytes,
+		RespBytes:        respBytes,
+	}
+	if !startTime.IsZero() {
+		entry.StartTime = startTime.UnixMilli()
+	}
+	if r.TLS != nil {
+		entry.TLSVersion = tls.VersionName(r.TLS.Version)
+		entry.TLSCipher = tls.CipherSuiteName(r.TLS.CipherSuite)
+	}
+	if info != nil {
+		entry.Hook = info.hookNote
+		entry.HookError = info.hookError
+		entry.InjectedDelayMs = info.injectedDelay.Milliseconds()
+		if info.injectedDelay > 0 && ok {
+			entry.RealLatencyMs = math.Round(float64(elapsed-info.injectedDelay)/1e4) / 100
+		}
+		entry.InjectedFault = info.injectedFault
+		entry.RateLimited = info.rateLimited
+		entry.Throttle = info.throttle
+		entry.Source = info.source
+		entry.Host = info.host
+		entry.Replayed = info.replayed
+		entry.Probe = info.probe
+		entry.Rewrite = info.rewrite
+		if info.originalPath != entry.Path {
+			entry.OriginalPath = info.originalPath
+		}
+		entry.CORSPreflight = info.corsPreflight
+		entry.Tag = info.tag
+		entry.Target = info.target
+		entry.Proxy = info.proxy
+		entry.MirrorPair = info.mirrorPair
+		entry.RequestID = info.requestID
+		if info.tunnel {
+			entry.Tunnel, entry.Path, entry.ReqBytes = true, info.host, info.tunnelUp
+		}
+		if info.attempts > 1 {
+			entry.Attempts = info.attempts
+		}
+		entry.StrippedHeaders = info.strippedHeaders
+		entry.SetHeaders = info.setHeaders
+		entry.ReqReplacements = info.reqReplacements
+		entry.RespReplacements = info.respReplacements
+		entry.RespChunks = info.respChunks
+		entry.ClientError = info.clientError
+	}
+	select {
+	case broadcast <- entry:
+		capturedCount.Add(1)
+		if info != nil {
+			info.recorded.Store(true)
+		}
+	default:
+		if droppedCount.Add(1)%1000 == 1 {
+			log.Printf("capture queue full: %d entries dropped so far", droppedCount.Load())
+		}
+	}
+}
+
+// respondSynthetic answers r directly without contacting the target, while
+// still running the response through the capture pipeline.
+func respondSynthetic(w http.ResponseWriter, r *http.Request, status int, header http.Header, body []byte) {
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+	captureResponse(resp)
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
 }
 
 func handleBroadcasts() {
+	defer close(broadcastsDone)
 	for {
 		// Grab the next log from the channel
-		msg := <-broadcast
-		// Send to CLI channel
-		cliChan <- msg
-		saveToHistory(msg)
-
-		// Send it to every connected client
-		clientsMu.Lock()
-		for client := range clients {
-			err := client.WriteJSON(msg)
-			if err != nil {
-				//log.Printf("Websocket error: %v", err)
-				client.Close()
-				delete(clients, client)
+		select {
+		case msg := <-broadcast:
+			deliverEntry(msg)
+		case <-stopBroadcasts:
+			return
+		}
+	}
+}
+
+// deliverEntry hands a captured entry to everything that consumes it. It
+// never blocks on a slow consumer.
+func deliverEntry(msg CombinedLog) {
+	// Save it and send it to every connected client
+	entry := publishEntry(msg)
+	// Send to CLI channel, unless nothing reads it (-no-cli)
+	if !noCLI && !cliShows(&entry) {
+		cliFiltered.Add(1)
+	} else if !noCLI {
+		select {
+		case cliChan <- entry:
+		default:
+			cliDropped.Add(1)
+		}
+	}
+	if accessLog != nil {
+		accessLog.write(entry)
+	}
+	notifyEntry(entry)
+}
+
+// broadcastEvent sends a non-entry message (it must carry a "type" field)
+// to the websocket clients only.
+func broadcastEvent(v any) {
+	writeClients(v)
+}
+
+// broadcastEntryEvent is broadcastEvent for an event that reveals e, such
+// as its new note: clients whose share link doesn't cover e don't get it.
+func broadcastEntryEvent(v any, e *CombinedLog) {
+	msg := &wsMessage{v: v}
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for c := range clients {
+		if c.share.filter().matches(e) {
+			if out := msg.encodedFor(c); out != nil {
+				c.enqueue(out)
 			}
 		}
-		clientsMu.Unlock()
 	}
 }
 
+// statusLine is the first line of the CLI header.
+func statusLine() string {
+	line := fmt.Sprintf("Session: online | Ignored: %d", ignoredCount.Load())
+	if n := droppedCount.Load() + cliDropped.Load(); n > 0 {
+		line += " | " + colorize("33", fmt.Sprintf("Not shown: %d", n))
+	}
+	if n := cliFiltered.Load(); n > 0 {
+		line += fmt.Sprintf(" | Filtered: %d", n)
+	}
+	if capturePaused.Load() {
+		line += fmt.Sprintf(" | %s (%d not recorded)", colorize("33", "Capture paused"), pausedCount.Load())
+	}
+	if summary, ok := probeSummary(); summary != "" {
+		dot := "32" // Green
+		if !ok {
+			dot = "31" // Red
+		}
+		line += fmt.Sprintf(" | Probes: %s %s", colorize(dot, "●"), summary)
+	}
+	return line
+}
+
 func startCLIDashboard(target, targetURL, customDomain string) {
-	// Clear screen and print static header once
-	fmt.Print("\033[H\033[2J")
-	fmt.Printf("Session: online\n")
-	fmt.Printf("Domain: %s | Forwarding: %s\n\n", customDomain, targetURL)
-	fmt.Println("\nHTTP Requests")
-	fmt.Println("-------------")
+	if cliFormat == "tsv" {
+		for msg := range cliChan {
+			if cliTemplate != nil {
+				printCLITemplate(msg)
+				continue
+			}
+			// Plain columns for column -t, cut, spreadsheets...
+			fmt.Printf("%s\t%s\t%d\t%s\t%s", msg.Time, msg.Method, msg.Status, msg.Latency, msg.Path)
+			if len(namedProxies) > 0 {
+				fmt.Print("\t" + msg.Proxy)
+			}
+			for _, name := range showHeaders {
+				v := entryHeader(msg, name)
+				if v == "" {
+					v = "-" // keep the columns aligned
+				}
+				fmt.Print("\t" + v)
+			}
+			fmt.Println()
+		}
+		return
+	}
+
+	shownStatus := statusLine()
+	// Piped elsewhere, the screen clear and pinned header would only be
+	// escape codes in the output.
+	pinned := !noDashboard && ansiOut
+	if pinned {
+		// Clear screen and print static header once
+		fmt.Print("\033[H\033[2J")
+		fmt.Println(shownStatus)
+		fmt.Printf("Domain: %s | Forwarding: %s\n\n", customDomain, targetURL)
+		fmt.Println("\nHTTP Requests")
+		fmt.Println("-------------")
+		// Pin the header: only lines below it scroll, so the counters stay visible
+		fmt.Print("\033[7r\033[7;1H")
+	}
 
+	report := filterReport{last: time.Now()}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
-		msg := <-cliChan // Read from dedicated CLI channel
-
-		// Color logic: Green for success, Red for errors
-		color := "32" // Green
-		if msg.Status >= 400 {
-			color = "31" // Red
-		}
-
-		// Fixed-width printing (no buffering, zero delay)
-		// %-12s  = 12 chars wide, left aligned
-		// %-6s   = 6 chars wide
-		// \033[%sm = ANSI Color start
-		// \033[0m  = ANSI Reset
-		fmt.Printf("%-12s %-6s %-35s \033[%sm%d OK\033[0m [%s]\n",
-			msg.Time,
-			msg.Method,
-			msg.Path,
-			color,
-			msg.Status,
-			msg.Latency,
-		)
+		var msg CombinedLog
+		select {
+		case msg = <-cliChan: // Read from dedicated CLI channel
+		case <-ticker.C:
+			if line := statusLine(); line != shownStatus && pinned {
+				shownStatus = line
+				// Save cursor, rewrite the first header line, restore cursor
+				fmt.Printf("\0337\033[1;1H\033[2K%s\0338", line)
+			}
+			if line := report.line(); line != "" {
+				fmt.Println(line)
+			}
+			continue
+		}
+		printCLIEntry(msg)
 	}
 }
 
-func saveToHistory(log CombinedLog) {
+// printCLIEntry prints msg's line in the pretty format, and its body too
+// with -show-error-body.
+func printCLIEntry(msg CombinedLog) {
+	if cliTemplate != nil {
+		printCLITemplate(msg)
+	} else {
+		printCLILine(msg)
+	}
+	if showErrorBody && msg.Status >= 400 && msg.RespBody != "" {
+		printErrorBody(msg.RespBody)
+	}
+}
+
+func printCLILine(msg CombinedLog) {
+	fmt.Println(cliLine(msg))
+}
+
+// cliLine is msg's line in the CLI dashboard, also used by -tui.
+func cliLine(msg CombinedLog) string {
+	// Fixed-width printing (no buffering, zero delay)
+	// %-12s  = 12 chars wide, left aligned
+	// %-6s   = 6 chars wide
+	return fmt.Sprintf("%s%-12s %s %-35s %s [%s]%s",
+		proxyLabel(msg.Proxy)+mirrorLabel(msg),
+		msg.Time,
+		colorize(methodColor(msg.Method), fmt.Sprintf("%-6s", msg.Method)),
+		msg.Path,
+		colorize(statusColor(msg.Status), statusLabel(msg.Status)),
+		msg.Latency,
+		shownHeaderValues(msg),
+	)
+}
+
+// showHeaders lists headers whose values are appended to CLI lines
+// (-show-header), e.g. a correlation ID.
+var showHeaders []string
+
+// entryHeader returns header name from e's request, or else its response.
+func entryHeader(e CombinedLog, name string) string {
+	if v := parseHeaderDump(e.ReqHeaders).Get(name); v != "" {
+		return v
+	}
+	return parseHeaderDump(e.RespHeaders).Get(name)
+}
+
+func shownHeaderValues(e CombinedLog) string {
+	var b strings.Builder
+	for _, name := range showHeaders {
+		if v := entryHeader(e, name); v != "" {
+			b.WriteString(" " + colorize("36", name+"="+v))
+		}
+	}
+	return b.String()
+}
+
+// cliTemplate replaces the built-in CLI line format (-cli-template).
+var cliTemplate *template.Template
+
+// parseCLITemplate parses a -cli-template. Besides the CombinedLog fields,
+// templates can use {{colorStatus .Status}}, {{colorMethod .Method}},
+// {{color "31" .Path}} and {{header . "X-Request-Id"}}.
+func parseCLITemplate(text string) (*template.Template, error) {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	t, err := template.New("cli").Funcs(template.FuncMap{
+		"color":       colorize,
+		"colorStatus": func(status int) string { return colorize(statusColor(status), strconv.Itoa(status)) },
+		"colorMethod": func(method string) string { return colorize(methodColors[method], method) },
+		"header":      entryHeader,
+	}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	// Catch unknown fields now rather than on the first request.
+	if err := t.Execute(io.Discard, CombinedLog{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func printCLITemplate(msg CombinedLog) {
+	if err := cliTemplate.Execute(os.Stdout, msg); err != nil {
+		fmt.Printf("\n-cli-template: %v\n", err)
+	}
+}
+
+// printErrorBody prints a truncated, indented response body in red below
+// the request line.
+func printErrorBody(body string) {
+	const maxLen = 500
+	if len(body) > maxLen {
+		body = body[:maxLen] + "..."
+	}
+	body = strings.TrimRight(body, "\r\n")
+	fmt.Println(colorize("31", "    "+strings.ReplaceAll(body, "\n", "\n    ")))
+}
+
+// noColor disables ANSI colors in the CLI (-no-color or NO_COLOR).
+var noColor bool
+
+// colorize wraps s in the ANSI color code, unless colors are off or
+// stdout isn't a terminal. An empty code leaves s as is.
+func colorize(code, s string) string {
+	if noColor || !ansiOut || code == "" {
+		return s
+	}
+	return "\033[" + code + "m" + s + "\033[0m"
+}
+
+// statusLabel is how the CLI shows a status: "404 Not Found", the bare
+// code when it has no standard text, or ERR for an entry without one.
+func statusLabel(status int) string {
+	if status == 0 {
+		return "ERR"
+	}
+	if text := http.StatusText(status); text != "" {
+		return fmt.Sprintf("%d %s", status, text)
+	}
+	return strconv.Itoa(status)
+}
+
+// statusColor picks the ANSI color for a status code by class.
+func statusColor(status int) string {
+	switch {
+	case status >= 500, status == 0:
+		return "31" // Red
+	case status >= 400:
+		return "35" // Magenta
+	case status >= 300:
+		return "33" // Yellow
+	case status >= 200:
+		return "32" // Green
+	}
+	return "36" // Cyan: 1xx
+}
+
+// saveToHistory appends log and returns the seqs of the entries that made
+// room for it.
+func saveToHistory(log CombinedLog) []uint64 {
 	historyMutex.Lock()
 	defer historyMutex.Unlock()
 
@@ -252,8 +1445,19 @@ func saveToHistory(log CombinedLog) {
 
 	// Keep only the latest logs (FIFO)
 	if len(history) > maxHistory {
-		history = history[1:]
+		evicted := historySeqs(history[:len(history)-maxHistory])
+		history = history[len(history)-maxHistory:]
+		return evicted
+	}
+	return nil
+}
+
+func historySeqs(entries []CombinedLog) []uint64 {
+	seqs := make([]uint64, len(entries))
+	for i, e := range entries {
+		seqs[i] = e.Seq
 	}
+	return seqs
 }
 
 // Note: In real code, use context.WithValue(r.Context(), "startTime", time.Now())
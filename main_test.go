@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// A CLI that stops reading (a paused terminal, a full pipe) must cost CLI
+// lines, not requests: recording and broadcasting carry on and the lost
+// lines are counted.
+func TestBlockedCLIReader(t *testing.T) {
+	noCLI = false
+	for len(cliChan) < cap(cliChan) {
+		cliChan <- CombinedLog{}
+	}
+	t.Cleanup(func() {
+		for len(cliChan) > 0 {
+			<-cliChan
+		}
+	})
+	const entries = 10
+	before := cliDropped.Load()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range entries {
+			resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: httptest.NewRequest("GET", "/blocked", nil)}
+			recordEntry(resp, "", "", "", false, 0, nil)
+			// One turn of handleBroadcasts, which itself never returns.
+			deliverEntry(<-broadcast)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("recording blocked on the full CLI queue")
+	}
+	if got := cliDropped.Load() - before; got != entries {
+		t.Errorf("cliDropped grew by %d, want %d", got, entries)
+	}
+}
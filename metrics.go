@@ -0,0 +1,100 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Prometheus metrics, written in the text exposition format by hand. Labels
+// are limited to the method and status class so cardinality stays bounded.
+var (
+	metricsMu       sync.Mutex
+	requestsTotal   = map[[2]string]uint64{} // {method, status class}
+	requestDuration = newHistogram(.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10)
+	requestBytes    = newHistogram(100, 1e3, 1e4, 1e5, 1e6, 1e7)
+	responseBytes   = newHistogram(100, 1e3, 1e4, 1e5, 1e6, 1e7)
+	upstreamErrors  atomic.Int64
+	standardMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE"}
+)
+
+type histogram struct {
+	bounds []float64
+	counts []uint64 // per bucket, not cumulative; last is +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i, _ := slices.BinarySearch(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cum uint64
+	for i, b := range h.bounds {
+		cum += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(b, 'g', -1, 64), cum)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", name, h.count, name, h.sum, name, h.count)
+}
+
+// observeRequest records a finished exchange, recorded or not. It runs on
+// the capture path, so it only takes metricsMu, never the history lock.
+func observeRequest(method string, status int, d time.Duration, reqLen, respLen int64) {
+	if !slices.Contains(standardMethods, method) {
+		method = "OTHER"
+	}
+	class := "other"
+	if status >= 100 && status < 600 {
+		class = strconv.Itoa(status/100) + "xx"
+	}
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	requestsTotal[[2]string{method, class}]++
+	requestDuration.observe(d.Seconds())
+	requestBytes.observe(float64(reqLen))
+	responseBytes.observe(float64(respLen))
+}
+
+// handleMetrics serves GET /metrics.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	historyMutex.Lock()
+	entries := len(history)
+	historyMutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metricsMu.Lock()
+	keys := make([][2]string, 0, len(requestsTotal))
+	for k := range requestsTotal {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b [2]string) int {
+		return cmp.Or(cmp.Compare(a[0], b[0]), cmp.Compare(a[1], b[1]))
+	})
+	fmt.Fprintf(w, "# HELP proxyeye_requests_total Proxied requests, recorded or not.\n# TYPE proxyeye_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(w, "proxyeye_requests_total{method=%q,status_class=%q} %d\n", k[0], k[1], requestsTotal[k])
+	}
+	requestDuration.write(w, "proxyeye_request_duration_seconds", "Time from receiving a request to finishing its response.")
+	requestBytes.write(w, "proxyeye_request_bytes", "Request body size (counted up to -max-body).")
+	responseBytes.write(w, "proxyeye_response_bytes", "Response body size (counted up to -max-body).")
+	metricsMu.Unlock()
+
+	fmt.Fprintf(w, "# HELP proxyeye_upstream_errors_total Requests the backend couldn't answer (502).\n# TYPE proxyeye_upstream_errors_total counter\nproxyeye_upstream_errors_total %d\n", upstreamErrors.Load())
+	fmt.Fprintf(w, "# HELP proxyeye_websocket_clients Connected inspector clients.\n# TYPE proxyeye_websocket_clients gauge\nproxyeye_websocket_clients %d\n", clientCount())
+	fmt.Fprintf(w, "# HELP proxyeye_history_entries Entries held in history.\n# TYPE proxyeye_history_entries gauge\nproxyeye_history_entries %d\n", entries)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Mirroring (-mirror): each request the main proxy forwards is also sent,
+// in the background, to a shadow backend. The client only ever gets the
+// primary response. Both responses are recorded; they share a mirror_pair
+// number and the shadow's entry has source "mirror".
+var (
+	mirrorURL      *url.URL
+	mirrorDirector func(*http.Request)       // the main proxy's, aimed at mirrorURL
+	mirrorSlots    = make(chan struct{}, 32) // copies in flight; more are dropped
+	mirrorPairs    atomic.Uint64
+
+	mirrorClient = &http.Client{
+		Transport: upstreamTransport,
+		Timeout:   30 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+)
+
+// setMirror parses -mirror: a port or an http(s) URL, like the target.
+func setMirror(s string) error {
+	if !strings.Contains(s, "://") {
+		s = "http://127.0.0.1:" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("want a port or an http(s) URL, got %q", s)
+	}
+	mirrorURL = u
+	mirrorDirector = proxyDirector(httputil.NewSingleHostReverseProxy(u).Director)
+	return nil
+}
+
+// startMirror sends a copy of r to -mirror, if r should be mirrored: only
+// the main proxy's requests to its own target, with a body that fits in
+// -max-body (larger uploads are streamed and can't be sent twice).
+func startMirror(r *http.Request, info *requestInfo) {
+	if mirrorURL == nil || info.target != "" || r.URL.IsAbs() ||
+		info.proxy != "" && info.proxy != defaultProxyName {
+		return
+	}
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		if r.ContentLength < 0 || r.ContentLength > maxBody.Load() {
+			return
+		}
+		body, _ = io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	select {
+	case mirrorSlots <- struct{}{}:
+	default:
+		log.Printf("mirror: too many copies in flight, skipped %s %s", r.Method, r.URL.Path)
+		return
+	}
+	info.mirrorPair = mirrorPairs.Add(1)
+
+	mirror := &requestInfo{
+		source: "mirror", target: mirrorURL.String(), mirrorPair: info.mirrorPair,
+		proxy: info.proxy, tag: info.tag, originalPath: info.originalPath,
+		skip: info.skip, captureType: info.captureType,
+	}
+	c := &bodyCapture{max: maxBody.Load()}
+	c.Write(body)
+	// Not r's context: the copy outlives the client's request.
+	ctx := context.WithValue(context.Background(), startTimeKey, time.Now())
+	ctx = context.WithValue(ctx, reqBodyKey, c)
+	ctx = context.WithValue(ctx, reqInfoKey, mirror)
+	req := r.Clone(ctx)
+	req.Body, req.ContentLength = http.NoBody, 0
+	if len(body) > 0 {
+		req.Body, req.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+	}
+	mirrorDirector(req)
+	go sendMirror(req)
+}
+
+func sendMirror(req *http.Request) {
+	defer func() { <-mirrorSlots }()
+	resp, err := mirrorClient.Do(req)
+	if err != nil {
+		log.Printf("mirror: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	captureResponse(resp)
+	io.Copy(io.Discard, resp.Body) // ends a teed stream, which records it
+}
+
+// mirrorLabel marks shadow responses in the CLI.
+func mirrorLabel(e CombinedLog) string {
+	if e.Source != "mirror" {
+		return ""
+	}
+	return colorize("90", "mirror") + " "
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// A minimal MessagePack encoder for /ws?encoding=msgpack. Structs follow
+// encoding/json's tags (names, omitempty, "-"), so a binary message decodes
+// to the same object as its JSON form. []byte becomes bin rather than
+// base64 text.
+
+// msgpackVersion is the first byte of every binary websocket message; the
+// MessagePack value follows. Bump it if the binary framing changes.
+const msgpackVersion = 1
+
+func marshalMsgpack(v any) ([]byte, error) {
+	return appendMsgpack([]byte{msgpackVersion}, reflect.ValueOf(v))
+}
+
+func appendMsgpack(b []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return append(b, 0xc0), nil
+	}
+	var err error
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		return appendMsgpack(b, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendMsgpackInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendMsgpackUint(b, v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return appendMsgpackString(b, v.String()), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b = appendMsgpackLen(b, v.Len(), 0, 0xc4, 0xc5, 0xc6)
+			return append(b, v.Bytes()...), nil
+		}
+		fallthrough
+	case reflect.Array:
+		b = appendMsgpackLen(b, v.Len(), 0x90, 0, 0xdc, 0xdd)
+		for i := range v.Len() {
+			if b, err = appendMsgpack(b, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		b = appendMsgpackLen(b, v.Len(), 0x80, 0, 0xde, 0xdf)
+		for it := v.MapRange(); it.Next(); {
+			b = appendMsgpackString(b, it.Key().String())
+			if b, err = appendMsgpack(b, it.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Struct:
+		type field struct {
+			name string
+			v    reflect.Value
+		}
+		var fields []field
+		t := v.Type()
+		for i := range t.NumField() {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if !f.IsExported() || tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if name == "" {
+				name = f.Name
+			}
+			fv := v.Field(i)
+			if strings.Contains(opts, "omitempty") && isEmptyValue(fv) {
+				continue
+			}
+			fields = append(fields, field{name, fv})
+		}
+		b = appendMsgpackLen(b, len(fields), 0x80, 0, 0xde, 0xdf)
+		for _, f := range fields {
+			b = appendMsgpackString(b, f.name)
+			if b, err = appendMsgpack(b, f.v); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type %s", v.Type())
+}
+
+// isEmptyValue is encoding/json's omitempty test.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return v.IsZero()
+	}
+	return false
+}
+
+// appendMsgpackLen writes a length header: the fix form (fix|n, for
+// fixarray/fixmap under 16) when fix is set, else the 8-bit form when
+// one8 is set, else 16 or 32 bits.
+func appendMsgpackLen(b []byte, n int, fix, one8, two16, four32 byte) []byte {
+	switch {
+	case fix != 0 && n < 16:
+		return append(b, fix|byte(n))
+	case one8 != 0 && n <= math.MaxUint8:
+		return append(b, one8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, two16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, four32), uint32(n))
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	if len(s) < 32 {
+		b = append(b, 0xa0|byte(len(s)))
+	} else {
+		b = appendMsgpackLen(b, len(s), 0, 0xd9, 0xda, 0xdb)
+	}
+	return append(b, s...)
+}
+
+func appendMsgpackInt(b []byte, n int64) []byte {
+	switch {
+	case n >= 0:
+		return appendMsgpackUint(b, uint64(n))
+	case n >= -32:
+		return append(b, byte(n)) // negative fixint
+	case n >= math.MinInt8:
+		return append(b, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(n))
+}
+
+func appendMsgpackUint(b []byte, n uint64) []byte {
+	switch {
+	case n < 128:
+		return append(b, byte(n)) // positive fixint
+	case n <= math.MaxUint8:
+		return append(b, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcf), n)
+}
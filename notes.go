@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxNote bounds the size of a note attached to a history entry.
+const maxNote = 64 << 10
+
+// handleNote sets the note on a history entry (PUT /history/{seq}/note,
+// plain-text body). An empty body removes it.
+func handleNote(w http.ResponseWriter, r *http.Request) {
+	seq, err := pathSeq(r)
+	if err != nil {
+		http.Error(w, "invalid seq", http.StatusBadRequest)
+		return
+	}
+	text, err := io.ReadAll(io.LimitReader(r.Body, maxNote+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(text) > maxNote {
+		http.Error(w, "note too long", http.StatusRequestEntityTooLarge)
+		return
+	}
+	note := strings.TrimSpace(string(text))
+
+	historyMutex.Lock()
+	index, ok := historyIndex(seq)
+	if !ok {
+		historyMutex.Unlock()
+		http.Error(w, "no such history entry", http.StatusNotFound)
+		return
+	}
+	history[index].Note = note
+	e := history[index]
+	historyMutex.Unlock()
+	broadcastEntryEvent(map[string]any{"type": "entry_annotated", "seq": e.Seq, "note": note}, &e)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"seq": seq, "note": note})
+}
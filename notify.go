@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notifications (-notify-url): a webhook is called when a captured entry
+// trips one of the conditions below. Each condition fires at most once per
+// -notify-window; later hits in the window are counted and reported with
+// the next message.
+var (
+	notifyURL       string
+	notifyFormat    = "json" // or "slack"
+	notifyWindow    = time.Minute
+	notify5xx       = true
+	notifyErrorRate float64       // percent of 5xx in the window; 0 = off
+	notifyP95       time.Duration // 0 = off
+	notifyMatchers  []routeMatcher
+	notifyBaseURL   string // where /inspect links point
+)
+
+// notifyMinSamples keeps the rate and p95 conditions quiet until the
+// window holds enough requests to mean something.
+const notifyMinSamples = 10
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+type notifySample struct {
+	at        time.Time
+	status    int
+	latencyMs float64
+}
+
+// notifier's samples are only touched by the broadcaster goroutine; the
+// debounce state is shared with probes, under mu.
+type notifier struct {
+	samples    []notifySample
+	mu         sync.Mutex
+	lastFired  map[string]time.Time
+	suppressed map[string]int
+	queue      chan notification
+}
+
+type notification struct {
+	Condition   string       `json:"condition"`
+	Message     string       `json:"message"`
+	Entry       entrySummary `json:"entry"`
+	InspectURL  string       `json:"inspect_url"`
+	Suppressed  int          `json:"suppressed,omitempty"` // hits debounced since the last message
+	WindowStats *notifyStats `json:"window,omitempty"`
+	Time        string       `json:"time"`
+}
+
+type notifyStats struct {
+	Requests  int     `json:"requests"`
+	ErrorRate float64 `json:"error_rate"` // percent
+	P95Ms     float64 `json:"p95_ms"`
+}
+
+var notifications *notifier
+
+// startNotifier validates the notify flags and starts the delivery loop.
+func startNotifier(matchSpecs []string) error {
+	if notifyFormat != "json" && notifyFormat != "slack" {
+		return fmt.Errorf("-notify-format: unknown format %q (want json or slack)", notifyFormat)
+	}
+	if notifyWindow <= 0 {
+		return fmt.Errorf("-notify-window must be positive")
+	}
+	for _, spec := range matchSpecs {
+		m := parseRoute(spec)
+		if err := m.compile(); err != nil {
+			return fmt.Errorf("-notify-match: %v", err)
+		}
+		notifyMatchers = append(notifyMatchers, m)
+	}
+	notifications = &notifier{
+		lastFired:  map[string]time.Time{},
+		suppressed: map[string]int{},
+		queue:      make(chan notification, 64),
+	}
+	go notifications.deliver()
+	return nil
+}
+
+// notifyEntry checks e against the conditions. It runs on the broadcaster
+// and never blocks: delivery happens in its own goroutine.
+func notifyEntry(e CombinedLog) {
+	n := notifications
+	if n == nil || e.Source != "" {
+		return // answered by ProxyEye itself (cache, replay...)
+	}
+	now := time.Now()
+	ms, _ := strconv.ParseFloat(strings.TrimSuffix(e.Latency, "ms"), 64)
+	n.samples = append(n.samples, notifySample{now, e.Status, ms})
+	cut := 0
+	for cut < len(n.samples) && now.Sub(n.samples[cut].at) > notifyWindow {
+		cut++
+	}
+	n.samples = n.samples[cut:]
+	stats := n.stats()
+
+	if notify5xx && e.Status >= 500 {
+		n.fire(now, "5xx", fmt.Sprintf("%s %s returned %d", e.Method, e.Path, e.Status), e, nil)
+	}
+	if notifyErrorRate > 0 && stats.Requests >= notifyMinSamples && stats.ErrorRate > notifyErrorRate {
+		n.fire(now, "error_rate", fmt.Sprintf("%.1f%% of the last %d requests failed (threshold %g%%)",
+			stats.ErrorRate, stats.Requests, notifyErrorRate), e, &stats)
+	}
+	if notifyP95 > 0 && stats.Requests >= notifyMinSamples && stats.P95Ms > float64(notifyP95.Milliseconds()) {
+		n.fire(now, "p95", fmt.Sprintf("p95 latency is %.0fms over the last %d requests (threshold %s)",
+			stats.P95Ms, stats.Requests, notifyP95), e, &stats)
+	}
+	for _, m := range notifyMatchers {
+		if (m.Method == "" || m.Method == e.Method) && m.pathRe.MatchString(e.Path) {
+			n.fire(now, "match", fmt.Sprintf("%s %s matched -notify-match %q", e.Method, e.Path, m.Path), e, nil)
+			break
+		}
+	}
+}
+
+func (n *notifier) stats() notifyStats {
+	s := notifyStats{Requests: len(n.samples)}
+	if s.Requests == 0 {
+		return s
+	}
+	errors := 0
+	latencies := make([]float64, len(n.samples))
+	for i, x := range n.samples {
+		if x.status >= 500 {
+			errors++
+		}
+		latencies[i] = x.latencyMs
+	}
+	slices.Sort(latencies)
+	s.ErrorRate = float64(errors) * 100 / float64(s.Requests)
+	s.P95Ms = latencies[(len(latencies)*95-1)/100]
+	return s
+}
+
+// fire queues a notification unless condition already fired in this window.
+func (n *notifier) fire(now time.Time, condition, message string, e CombinedLog, stats *notifyStats) {
+	n.fireKey(now, condition, condition, message, e, stats)
+}
+
+// fireKey is fire with its own debounce key, for conditions that several
+// sources raise independently, such as each probe.
+func (n *notifier) fireKey(now time.Time, key, condition, message string, e CombinedLog, stats *notifyStats) {
+	n.mu.Lock()
+	if now.Sub(n.lastFired[key]) < notifyWindow {
+		n.suppressed[key]++
+		n.mu.Unlock()
+		return
+	}
+	n.lastFired[key] = now
+	suppressed := n.suppressed[key]
+	n.suppressed[key] = 0
+	n.mu.Unlock()
+	msg := notification{
+		Condition:   condition,
+		Message:     message,
+		Entry:       summarize(e),
+		InspectURL:  fmt.Sprintf("%s/inspect#seq=%d", notifyBaseURL, e.Seq),
+		Suppressed:  suppressed,
+		WindowStats: stats,
+		Time:        now.Format(time.RFC3339),
+	}
+	select {
+	case n.queue <- msg:
+	default:
+		log.Printf("notify: queue full, dropped %s notification", condition)
+	}
+}
+
+// notifyProbeDown reports a probe that started failing. Each probe is
+// debounced on its own, so a flapping one can't hide another.
+func notifyProbeDown(p *probe, reason string) {
+	n := notifications
+	if n == nil {
+		return
+	}
+	e := p.req
+	n.fireKey(time.Now(), fmt.Sprintf("probe:%d", p.ID), "probe",
+		fmt.Sprintf("probe %d (%s %s) is down: %s", p.ID, e.Method, e.Path, reason), e, nil)
+}
+
+func (n *notifier) deliver() {
+	for msg := range n.queue {
+		var payload any = msg
+		if notifyFormat == "slack" {
+			text := fmt.Sprintf("*ProxyEye*: %s\n<%s|Open in the inspector>", msg.Message, msg.InspectURL)
+			if msg.Suppressed > 0 {
+				text += fmt.Sprintf(" (%d more since the last alert)", msg.Suppressed)
+			}
+			payload = map[string]string{"text": text}
+		}
+		body, _ := json.Marshal(payload)
+		resp, err := notifyClient.Post(notifyURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("notify: %v", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("notify: %s answered %s", notifyURL, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// In-flight requests: websocket clients get a request_started event as
+// soon as the proxy receives a request, so a long upload or slow response
+// shows up before it completes. Its entry carries the same request_id.
+// A request that ends without an entry (client gone, upstream error, or
+// not recorded after all) gets request_failed, so nothing stays pending.
+
+// pendingIDs numbers announced requests. Entries only get a seq once they
+// are published, which is too late for this.
+var pendingIDs atomic.Uint64
+
+// startPending announces r unless it won't be recorded (-capture-only,
+// -ignore, paused capture). The returned func, run when serveProxied
+// returns, sends request_failed if no entry was recorded for r.
+func startPending(r *http.Request, info *requestInfo) func() {
+	if info.skip {
+		return func() {}
+	}
+	info.requestID = pendingIDs.Add(1)
+	start, _ := r.Context().Value(startTimeKey).(time.Time)
+	// What the share filter can see of it so far.
+	stub := CombinedLog{Method: r.Method, Path: r.URL.Path, Proxy: info.proxy, Tag: info.tag}
+	broadcastEntryEvent(map[string]any{
+		"type": "request_started", "id": info.requestID,
+		"method": r.Method, "path": r.URL.Path, "query_string": r.URL.RawQuery,
+		"client": r.RemoteAddr, "proxy": info.proxy, "start_time": start.UnixMilli(),
+	}, &stub)
+	return func() {
+		if info.recorded.Load() {
+			return
+		}
+		reason := "not recorded"
+		switch {
+		case r.Context().Err() != nil:
+			reason = "client disconnected"
+		case info.upstreamErr != "":
+			reason = info.upstreamErr
+		}
+		broadcastEntryEvent(map[string]any{
+			"type": "request_failed", "id": info.requestID, "error": reason,
+			"elapsed_ms": time.Since(start).Milliseconds(),
+		}, &stub)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// playbackSpec selects what POST /api/replay/session plays back.
+type playbackSpec struct {
+	Entries []uint64 `json:"entries"` // seqs; empty = all (or all with Tag)
+	Tag     string   `json:"tag"`
+	Timing  string   `json:"timing"` // "original" (default) or "none"
+	Speed   float64  `json:"speed"`  // 2 = twice as fast; default 1
+}
+
+// playback is a running session replay. Requests are scheduled by their
+// original start time, so ones that overlapped are sent concurrently.
+type playback struct {
+	ID                        int
+	State                     string // "playing", "paused", "cancelled" or "done"
+	Total, Sent, Done, Failed int
+
+	mu         sync.Mutex
+	clockStart time.Time // playback time 0, moved forward by pauses
+	pausedAt   time.Time
+	wake       chan struct{}
+}
+
+type playbackItem struct {
+	entry CombinedLog
+	at    time.Duration // offset from the first request's start
+}
+
+// maxFinishedPlaybacks is how many done or cancelled playbacks GET still
+// reports; older ones are forgotten and answer 404.
+const maxFinishedPlaybacks = 20
+
+var (
+	playbacksMu       sync.Mutex
+	playbacks         = map[int]*playback{}
+	finishedPlaybacks []int // oldest first
+	nextPlaybackID    = 1
+)
+
+// entryStart returns when e's request started. Entries saved before
+// start_time existed only have the completion time, so it is estimated
+// from the latency.
+func entryStart(e CombinedLog) (time.Time, bool) {
+	if e.StartTime > 0 {
+		return time.UnixMilli(e.StartTime), true
+	}
+	t, err := time.Parse("2006-01-02T15:04:05.000Z07:00", e.TimeISO)
+	if err != nil {
+		return time.Time{}, false
+	}
+	ms, _ := strconv.ParseFloat(strings.TrimSuffix(e.Latency, "ms"), 64)
+	return t.Add(-time.Duration(ms * float64(time.Millisecond))), true
+}
+
+func playbackItems(spec playbackSpec) []playbackItem {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	selected := history
+	if len(spec.Entries) > 0 {
+		selected = nil
+		for _, seq := range spec.Entries {
+			if i, ok := historyIndex(seq); ok {
+				selected = append(selected, history[i])
+			}
+		}
+	}
+	var items []playbackItem
+	var first time.Time
+	for _, e := range selected {
+		if spec.Tag != "" && e.Tag != spec.Tag {
+			continue
+		}
+		start, _ := entryStart(e)
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+		items = append(items, playbackItem{entry: e, at: time.Duration(start.UnixNano())})
+	}
+	for i := range items {
+		items[i].at -= time.Duration(first.UnixNano())
+		if spec.Timing == "none" {
+			items[i].at = 0
+		} else {
+			items[i].at = time.Duration(float64(items[i].at) / spec.Speed)
+		}
+	}
+	slices.SortStableFunc(items, func(a, b playbackItem) int { return cmp.Compare(a.at, b.at) })
+	return items
+}
+
+func (p *playback) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// waitUntil blocks until playback time reaches at, honouring pauses. It
+// returns false if the playback was cancelled.
+func (p *playback) waitUntil(at time.Duration) bool {
+	for {
+		p.mu.Lock()
+		state, remaining := p.State, at-time.Since(p.clockStart)
+		p.mu.Unlock()
+		switch {
+		case state == "cancelled":
+			return false
+		case state == "paused":
+			<-p.wake
+		case remaining <= 0:
+			return true
+		default:
+			t := time.NewTimer(remaining)
+			select {
+			case <-t.C:
+			case <-p.wake:
+				t.Stop()
+			}
+		}
+	}
+}
+
+// progress sends the playback's state to websocket clients.
+func (p *playback) progress(extra map[string]any) {
+	p.mu.Lock()
+	ev := map[string]any{
+		"type": "playback", "id": p.ID, "state": p.State,
+		"total": p.Total, "sent": p.Sent, "done": p.Done, "failed": p.Failed,
+	}
+	p.mu.Unlock()
+	for k, v := range extra {
+		ev[k] = v
+	}
+	broadcastEvent(ev)
+}
+
+func (p *playback) run(items []playbackItem) {
+	client := newReplayClient(nil)
+	var wg sync.WaitGroup
+	for _, it := range items {
+		if !p.waitUntil(it.at) {
+			break
+		}
+		p.mu.Lock()
+		p.Sent++
+		p.mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := sendReplay(client, it.entry)
+			p.mu.Lock()
+			p.Done++
+			if res.Error != "" {
+				p.Failed++
+			}
+			p.mu.Unlock()
+			p.progress(map[string]any{"seq": res.Seq, "status": res.Status, "error": res.Error})
+		}()
+	}
+	wg.Wait()
+	p.mu.Lock()
+	if p.State != "cancelled" {
+		p.State = "done"
+	}
+	p.mu.Unlock()
+	p.progress(nil)
+	p.retire()
+}
+
+// retire records p as finished and forgets the oldest finished playbacks
+// beyond maxFinishedPlaybacks.
+func (p *playback) retire() {
+	playbacksMu.Lock()
+	defer playbacksMu.Unlock()
+	finishedPlaybacks = append(finishedPlaybacks, p.ID)
+	if n := len(finishedPlaybacks) - maxFinishedPlaybacks; n > 0 {
+		for _, id := range finishedPlaybacks[:n] {
+			delete(playbacks, id)
+		}
+		finishedPlaybacks = slices.Delete(finishedPlaybacks, 0, n)
+	}
+}
+
+func writePlayback(w http.ResponseWriter, p *playback) {
+	p.mu.Lock()
+	out := map[string]any{
+		"id": p.ID, "state": p.State, "total": p.Total,
+		"sent": p.Sent, "done": p.Done, "failed": p.Failed,
+	}
+	p.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handlePlaybackStart starts a session playback (POST /api/replay/session).
+func handlePlaybackStart(w http.ResponseWriter, r *http.Request) {
+	spec := playbackSpec{Timing: "original", Speed: 1}
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if spec.Timing != "original" && spec.Timing != "none" {
+		http.Error(w, "timing must be original or none", http.StatusBadRequest)
+		return
+	}
+	if spec.Speed <= 0 {
+		http.Error(w, "speed must be positive", http.StatusBadRequest)
+		return
+	}
+	items := playbackItems(spec)
+	if len(items) == 0 {
+		http.Error(w, "no matching history entries", http.StatusBadRequest)
+		return
+	}
+	p := &playback{State: "playing", Total: len(items), clockStart: time.Now(), wake: make(chan struct{}, 1)}
+	playbacksMu.Lock()
+	p.ID = nextPlaybackID
+	nextPlaybackID++
+	playbacks[p.ID] = p
+	playbacksMu.Unlock()
+	go p.run(items)
+	writePlayback(w, p)
+}
+
+// handlePlaybackControl handles GET /api/replay/session/{id} and
+// POST /api/replay/session/{id}/{action} (pause, resume, cancel).
+func handlePlaybackControl(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	playbacksMu.Lock()
+	p := playbacks[id]
+	playbacksMu.Unlock()
+	if p == nil {
+		http.Error(w, "no such playback", http.StatusNotFound)
+		return
+	}
+	if action := r.PathValue("action"); action != "" {
+		p.mu.Lock()
+		switch {
+		case action == "pause" && p.State == "playing":
+			p.State, p.pausedAt = "paused", time.Now()
+		case action == "resume" && p.State == "paused":
+			p.State = "playing"
+			p.clockStart = p.clockStart.Add(time.Since(p.pausedAt))
+		case action == "cancel" && (p.State == "playing" || p.State == "paused"):
+			p.State = "cancelled"
+		case action != "pause" && action != "resume" && action != "cancel":
+			p.mu.Unlock()
+			http.Error(w, "action must be pause, resume or cancel", http.StatusBadRequest)
+			return
+		}
+		p.mu.Unlock()
+		p.signal()
+		p.progress(nil)
+	}
+	writePlayback(w, p)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Long polling (GET /poll) is the live feed for networks that strip
+// websocket upgrades. The inspector falls back to it on its own.
+const (
+	defaultPollTimeout = 25 * time.Second
+	maxPollTimeout     = 60 * time.Second
+	pollBatch          = 500 // entries per response; the rest come with the next poll
+)
+
+// pollWake is closed, and replaced, whenever an entry is published, waking
+// every waiting poll at once. Guarded by clientsMu.
+var pollWake = make(chan struct{})
+
+// wakePolls is called by publishEntry, under clientsMu.
+func wakePolls() {
+	close(pollWake)
+	pollWake = make(chan struct{})
+}
+
+// pollEntries returns the entries after cursor that filter lets through,
+// the cursor to poll from next, and whether entries after cursor are gone
+// (evicted, cleared, or from before a restart). Callers hold clientsMu.
+func pollEntries(cursor uint64, filter *wsFilter) (entries []CombinedLog, next uint64, reset bool) {
+	if cursor > entrySeq { // ProxyEye restarted: start over
+		cursor, reset = 0, true
+	}
+	next = entrySeq
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	first := true
+	for _, e := range history {
+		if e.Seq <= cursor {
+			continue
+		}
+		if first && e.Seq != cursor+1 {
+			reset = true
+		}
+		first = false
+		if len(entries) == pollBatch {
+			next = entries[len(entries)-1].Seq
+			break
+		}
+		if filter.matches(&e) {
+			entries = append(entries, e)
+		}
+	}
+	if first && entrySeq > cursor { // everything after cursor is gone
+		reset = true
+	}
+	return entries, next, reset
+}
+
+// handlePoll serves GET /poll?cursor=SEQ&timeout=25s: the entries captured
+// after SEQ, the last seq seen, waiting up to timeout for there to be
+// some. The reply is {"entries": [...], "cursor": N, "reset": bool}; pass
+// cursor back on the next poll. reset means some entries after SEQ are no
+// longer in history. ?mode=summary sends summaries, as on /ws.
+func handlePoll(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var cursor uint64
+	if v := q.Get("cursor"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "cursor must be a sequence number", http.StatusBadRequest)
+			return
+		}
+		cursor = n
+	}
+	timeout := defaultPollTimeout
+	if v := q.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > maxPollTimeout {
+			http.Error(w, "timeout must be a duration up to "+maxPollTimeout.String(), http.StatusBadRequest)
+			return
+		}
+		timeout = d
+	}
+	switch q.Get("mode") {
+	case "", "full", "summary":
+	default:
+		http.Error(w, "mode must be full or summary", http.StatusBadRequest)
+		return
+	}
+	// The inspector's write timeout would cut a long poll short.
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + apiWriteTimeout))
+
+	filter := requestShare(r).filter()
+	expired := time.NewTimer(timeout)
+	defer expired.Stop()
+	for {
+		clientsMu.Lock()
+		entries, next, reset := pollEntries(cursor, filter)
+		wake := pollWake
+		clientsMu.Unlock()
+		if len(entries) > 0 || reset {
+			writePoll(w, q.Get("mode") == "summary", entries, next, reset)
+			return
+		}
+		cursor = next // skip entries the share filter hides
+		select {
+		case <-wake:
+		case <-expired.C:
+			writePoll(w, false, nil, cursor, false)
+			return
+		case <-stopping:
+			writePoll(w, false, nil, cursor, false)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writePoll(w http.ResponseWriter, summary bool, entries []CombinedLog, cursor uint64, reset bool) {
+	var list any = entries
+	if entries == nil {
+		list = []CombinedLog{}
+	} else if summary {
+		list = summarizeAll(entries)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{"entries": list, "cursor": cursor, "reset": reset})
+}
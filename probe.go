@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// probeHeader tags requests sent by a probe so their entries can be told
+// apart; it is stripped before forwarding.
+const probeHeader = "X-ProxyEye-Probe"
+
+// probe replays a captured request on a schedule and checks the answer.
+type probe struct {
+	ID           int            `json:"id"`
+	Entry        uint64         `json:"entry"` // seq
+	Interval     string         `json:"interval"`
+	ExpectStatus int            `json:"expect_status,omitempty"` // default: any 2xx
+	ExpectJSON   map[string]any `json:"expect_json,omitempty"`   // must be a subset of the response body
+	Status       string         `json:"status"`                  // "pending", "up", "down" or "paused"
+	LastRun      string         `json:"last_run,omitempty"`
+	LastError    string         `json:"last_error,omitempty"`
+
+	req   CombinedLog // snapshot: the entry itself may be evicted from history
+	every time.Duration
+	stop  chan struct{}
+}
+
+var (
+	probesMu    sync.Mutex
+	probes      []*probe
+	nextProbeID = 1
+)
+
+// check compares a probe response against the expectations.
+func (p *probe) check(status int, body []byte) error {
+	if p.ExpectStatus != 0 && status != p.ExpectStatus {
+		return fmt.Errorf("status %d, want %d", status, p.ExpectStatus)
+	}
+	if p.ExpectStatus == 0 && status/100 != 2 {
+		return fmt.Errorf("status %d, want 2xx", status)
+	}
+	if p.ExpectJSON != nil {
+		var got any
+		if err := json.Unmarshal(body, &got); err != nil {
+			return fmt.Errorf("response is not JSON: %v", err)
+		}
+		if !jsonContains(p.ExpectJSON, got) {
+			return fmt.Errorf("response body does not match expect_json")
+		}
+	}
+	return nil
+}
+
+// jsonContains reports whether every field in want is present in got with
+// the same value; nested objects are compared the same way.
+func jsonContains(want, got any) bool {
+	wm, ok := want.(map[string]any)
+	if !ok {
+		return reflect.DeepEqual(want, got)
+	}
+	gm, ok := got.(map[string]any)
+	if !ok {
+		return false
+	}
+	for k, v := range wm {
+		if gv, ok := gm[k]; !ok || !jsonContains(v, gv) {
+			return false
+		}
+	}
+	return true
+}
+
+// targetUp reports whether the probe's backend is up, so a stopped server
+// reads as "paused" rather than failing. For the target that is what the
+// last health check found (up until the first one finishes); a
+// forward-proxy entry's own host is dialled.
+func (p *probe) targetUp() bool {
+	if p.req.Host == "" {
+		healthMu.Lock()
+		defer healthMu.Unlock()
+		return lastHealth == nil || lastHealth.OK
+	}
+	addr := p.req.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "80")
+	}
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func (p *probe) run() {
+	status, errMsg := "up", ""
+	if !p.targetUp() {
+		status, errMsg = "paused", "target is down"
+	} else if err := p.send(); err != nil {
+		status, errMsg = "down", err.Error()
+	}
+	probesMu.Lock()
+	was := p.Status
+	p.Status, p.LastError = status, errMsg
+	p.LastRun = time.Now().Format("2006-01-02T15:04:05.000Z07:00")
+	probesMu.Unlock()
+	if status == "down" && was != "down" {
+		notifyProbeDown(p, errMsg)
+	}
+}
+
+func (p *probe) send() error {
+	req, err := newReplayRequest(p.req, false)
+	if err != nil {
+		return err
+	}
+	req.Header.Del(replayHeader)
+	req.Header.Set(probeHeader, strconv.Itoa(p.ID))
+	resp, err := newReplayClient(nil).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return p.check(resp.StatusCode, body)
+}
+
+func (p *probe) loop() {
+	p.run()
+	t := time.NewTicker(p.every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.run()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// probeSummary is shown in the CLI header, e.g. "2/3 up"; ok is false
+// when any probe is failing.
+func probeSummary() (summary string, ok bool) {
+	probesMu.Lock()
+	defer probesMu.Unlock()
+	if len(probes) == 0 {
+		return "", true
+	}
+	up, ok := 0, true
+	for _, p := range probes {
+		switch p.Status {
+		case "up":
+			up++
+		case "down":
+			ok = false
+		}
+	}
+	return fmt.Sprintf("%d/%d up", up, len(probes)), ok
+}
+
+func writeProbes(w http.ResponseWriter) {
+	probesMu.Lock()
+	out := make([]probe, len(probes))
+	for i, p := range probes {
+		out[i] = *p
+	}
+	probesMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"probes": out})
+}
+
+// handleProbesAPI lists probes (GET) or registers one (POST
+// {"entry": 3, "interval": "30s", "expect_status": 200}).
+func handleProbesAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var p probe
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		every, err := time.ParseDuration(p.Interval)
+		if err != nil || every < time.Second {
+			http.Error(w, "interval must be a duration of at least 1s", http.StatusBadRequest)
+			return
+		}
+		e, ok := historyBySeq(p.Entry)
+		if !ok {
+			http.Error(w, "no such history entry", http.StatusBadRequest)
+			return
+		}
+		p.req, p.every, p.stop, p.Status = e, every, make(chan struct{}), "pending"
+		probesMu.Lock()
+		p.ID = nextProbeID
+		nextProbeID++
+		probes = append(probes, &p)
+		probesMu.Unlock()
+		go p.loop()
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeProbes(w)
+}
+
+// handleProbeDelete stops and removes a probe (DELETE /api/probes/{id}).
+func handleProbeDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid probe id", http.StatusBadRequest)
+		return
+	}
+	probesMu.Lock()
+	for i, p := range probes {
+		if p.ID == id {
+			close(p.stop)
+			probes = append(probes[:i], probes[i+1:]...)
+			probesMu.Unlock()
+			writeProbes(w)
+			return
+		}
+	}
+	probesMu.Unlock()
+	http.Error(w, "no such probe", http.StatusNotFound)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// namedProxy is one -proxy definition: another listener with its own
+// target, feeding the same history, websocket and CLI as the main proxy.
+// Entries carry its name in "proxy".
+type namedProxy struct {
+	Name   string `json:"name"`
+	Listen string `json:"listen"`
+	Target string `json:"target"`
+
+	proxy *httputil.ReverseProxy
+	ln    net.Listener
+}
+
+var (
+	namedProxies []*namedProxy
+	proxyByName  = map[string]*namedProxy{}
+)
+
+// defaultProxyName labels the main proxy's entries once -proxy is used.
+const defaultProxyName = "default"
+
+var proxyNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// parseProxySpec parses "name=NAME,listen=[HOST:]PORT,target=URL|PORT".
+func parseProxySpec(spec string) (*namedProxy, error) {
+	p := &namedProxy{}
+	for _, field := range strings.Split(spec, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, fmt.Errorf("expected key=value, got %q", field)
+		}
+		switch k {
+		case "name":
+			p.Name = v
+		case "listen":
+			p.Listen = v
+		case "target":
+			p.Target = v
+		default:
+			return nil, fmt.Errorf("unknown key %q (want name, listen or target)", k)
+		}
+	}
+	if !proxyNameRe.MatchString(p.Name) || p.Name == defaultProxyName {
+		return nil, fmt.Errorf("name must be letters, digits, '.', '_' or '-', and not %q", defaultProxyName)
+	}
+	if p.Listen == "" || p.Target == "" {
+		return nil, fmt.Errorf("%s: listen and target are required", p.Name)
+	}
+	if !strings.Contains(p.Target, "://") {
+		p.Target = "http://127.0.0.1:" + p.Target // a bare port, like the main target
+	}
+	u, err := url.Parse(p.Target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s: target must be a port or an http(s) URL, got %q", p.Name, p.Target)
+	}
+	return p, nil
+}
+
+// startNamedProxies binds every -proxy listener (bare ports on bindHost)
+// and builds its reverse proxy from base, the configured main proxy.
+func startNamedProxies(specs []string, bindHost string, base *httputil.ReverseProxy) error {
+	for _, spec := range specs {
+		p, err := parseProxySpec(spec)
+		if err != nil {
+			return fmt.Errorf("-proxy: %v", err)
+		}
+		if proxyByName[p.Name] != nil {
+			return fmt.Errorf("-proxy: name %q is used twice", p.Name)
+		}
+		if p.ln, err = net.Listen("tcp", listenAddr(bindHost, p.Listen)); err != nil {
+			return fmt.Errorf("-proxy %s: %v", p.Name, err)
+		}
+		u, _ := url.Parse(p.Target)
+		if isLocalHost(u.Hostname()) && u.Port() == strconv.Itoa(p.ln.Addr().(*net.TCPAddr).Port) {
+			return fmt.Errorf("-proxy %s: target %s is its own listener", p.Name, p.Target)
+		}
+		rp := *base
+		rp.Director = proxyDirector(httputil.NewSingleHostReverseProxy(u).Director)
+		p.proxy = &rp
+		namedProxies = append(namedProxies, p)
+		proxyByName[p.Name] = p
+	}
+	for _, p := range namedProxies {
+		go serve(p.ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serveProxied(w, r.WithContext(context.WithValue(r.Context(), proxyNameKey, p.Name)), p.proxy)
+		}))
+	}
+	return nil
+}
+
+// requestProxyName is the proxy r arrived on: "" without -proxy.
+func requestProxyName(r *http.Request) string {
+	if name, ok := r.Context().Value(proxyNameKey).(string); ok {
+		return name
+	}
+	if len(namedProxies) > 0 {
+		return defaultProxyName
+	}
+	return ""
+}
+
+// isNamedProxyHost reports whether host is a -proxy listener, as
+// replayURL writes it.
+func isNamedProxyHost(host string) bool {
+	for _, p := range namedProxies {
+		if u, _ := url.Parse(listenerURL(p.ln)); u.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyLabel colors a CLI prefix naming the entry's proxy, picking the
+// color from the name so each service keeps its own.
+func proxyLabel(name string) string {
+	if name == "" {
+		return ""
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	colors := []string{"32", "33", "34", "35", "36", "92", "93", "94", "95", "96"}
+	return colorize(colors[h.Sum32()%uint32(len(colors))], "["+name+"]") + " "
+}
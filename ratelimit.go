@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitConfig is the user-facing rate limiter configuration.
+type rateLimitConfig struct {
+	Rate  string `json:"rate"`  // e.g. "10rps", "600/m"; empty disables limiting
+	Burst int    `json:"burst"` // bucket size; defaults to the per-second rate
+	Key   string `json:"key"`   // "ip" (default) or "header:Name"
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a keyed token bucket applied to proxied traffic only.
+type rateLimiter struct {
+	mu      sync.Mutex
+	cfg     rateLimitConfig
+	perSec  float64
+	buckets map[string]*tokenBucket
+	keyFunc func(*http.Request) string // overrides cfg.Key
+}
+
+var limiter = &rateLimiter{}
+
+// parseRate accepts "10rps", "10/s", "600rpm", "600/m" or a bare number (per second).
+func parseRate(spec string) (float64, error) {
+	s := strings.ToLower(strings.TrimSpace(spec))
+	unit := time.Second
+	for _, suffix := range []string{"rps", "/s"} {
+		s = strings.TrimSuffix(s, suffix)
+	}
+	for _, suffix := range []string{"rpm", "/m"} {
+		if strings.HasSuffix(s, suffix) {
+			s, unit = strings.TrimSuffix(s, suffix), time.Minute
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", spec)
+	}
+	return n / unit.Seconds(), nil
+}
+
+func (l *rateLimiter) configure(cfg rateLimitConfig) error {
+	var perSec float64
+	if cfg.Rate != "" {
+		var err error
+		if perSec, err = parseRate(cfg.Rate); err != nil {
+			return err
+		}
+	}
+	if cfg.Key == "" {
+		cfg.Key = "ip"
+	}
+	if cfg.Key != "ip" && !strings.HasPrefix(cfg.Key, "header:") {
+		return fmt.Errorf("invalid rate limit key %q (want ip or header:Name)", cfg.Key)
+	}
+	if cfg.Burst < 0 {
+		return fmt.Errorf("invalid burst %d", cfg.Burst)
+	}
+	if cfg.Burst == 0 && perSec > 0 {
+		cfg.Burst = int(math.Max(1, math.Ceil(perSec)))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg, l.perSec = cfg, perSec
+	l.buckets = make(map[string]*tokenBucket)
+	return nil
+}
+
+func (l *rateLimiter) config() rateLimitConfig {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cfg
+}
+
+// clientKey identifies the caller according to the configured key.
+func (l *rateLimiter) clientKey(r *http.Request) string {
+	if l.keyFunc != nil {
+		return l.keyFunc(r)
+	}
+	if name, ok := strings.CutPrefix(l.cfg.Key, "header:"); ok {
+		return r.Header.Get(name)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allow takes a token for r's client, returning how long to wait otherwise.
+func (l *rateLimiter) allow(r *http.Request) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perSec == 0 {
+		return true, 0
+	}
+	now := time.Now()
+	k := l.clientKey(r)
+	b, ok := l.buckets[k]
+	if !ok {
+		if len(l.buckets) > 10000 {
+			l.buckets = make(map[string]*tokenBucket)
+		}
+		b = &tokenBucket{tokens: float64(l.cfg.Burst), last: now}
+		l.buckets[k] = b
+	}
+	b.tokens = math.Min(float64(l.cfg.Burst), b.tokens+now.Sub(b.last).Seconds()*l.perSec)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.perSec * float64(time.Second))
+}
+
+// applyRateLimit answers over-limit requests with a 429 and reports whether
+// it did so.
+func applyRateLimit(w http.ResponseWriter, r *http.Request, info *requestInfo) bool {
+	ok, wait := limiter.allow(r)
+	if ok {
+		return false
+	}
+	info.rateLimited = true
+	retry := int(math.Ceil(wait.Seconds()))
+	header := http.Header{
+		"Content-Type": {"text/plain; charset=utf-8"},
+		"Retry-After":  {strconv.Itoa(retry)},
+	}
+	respondSynthetic(w, r, http.StatusTooManyRequests, header, []byte("ProxyEye rate limit exceeded\n"))
+	return true
+}
+
+func handleRateLimitAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var cfg rateLimitConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := limiter.configure(cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(limiter.config())
+}
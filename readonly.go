@@ -0,0 +1,19 @@
+package main
+
+import "net/http"
+
+// readOnly disables every endpoint that could change proxy behaviour or
+// send traffic to the backend, so the inspector can be shared safely.
+var readOnly bool
+
+// guardWrites rejects state-changing requests with 403 in -read-only mode,
+// leaving GET/HEAD (inspection) available.
+func guardWrites(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if readOnly && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "ProxyEye is running in read-only mode", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
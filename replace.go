@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// replaceRule rewrites text in request or response bodies. Literal by
+// default; a "re:" prefix makes the pattern a regex ($1 for groups).
+type replaceRule struct {
+	re          *regexp.Regexp
+	replacement []byte
+	literal     bool
+	typeRe      *regexp.Regexp // Content-Type restriction; nil = any text type
+}
+
+var reqReplaceRules, respReplaceRules []*replaceRule
+
+// parseReplaceRule parses "[re:]PATTERN=>REPLACEMENT[ type=CONTENT-TYPE]".
+func parseReplaceRule(spec string) (*replaceRule, error) {
+	rule := &replaceRule{}
+	if i := strings.LastIndex(spec, " type="); i >= 0 {
+		re, err := regexp.Compile(strings.TrimSpace(spec[i+len(" type="):]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid content type pattern in %q: %v", spec, err)
+		}
+		rule.typeRe, spec = re, spec[:i]
+	}
+	pattern, replacement, ok := strings.Cut(spec, "=>")
+	if !ok || pattern == "" {
+		return nil, fmt.Errorf("expected PATTERN=>REPLACEMENT in %q", spec)
+	}
+	rule.replacement = []byte(replacement)
+	if p, ok := strings.CutPrefix(pattern, "re:"); ok {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", p, err)
+		}
+		rule.re = re
+	} else {
+		rule.re, rule.literal = regexp.MustCompile(regexp.QuoteMeta(pattern)), true
+	}
+	return rule, nil
+}
+
+func isTextType(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	return strings.HasPrefix(mt, "text/") || strings.Contains(mt, "json") || strings.Contains(mt, "xml") ||
+		strings.Contains(mt, "javascript") || mt == "application/x-www-form-urlencoded"
+}
+
+func (rule *replaceRule) applies(contentType string) bool {
+	if rule.typeRe != nil {
+		return rule.typeRe.MatchString(contentType)
+	}
+	return isTextType(contentType)
+}
+
+// replaceBody runs the rules that apply to contentType over body. It returns
+// nil when no rule applies; otherwise the number of replacements made (which
+// may be 0, so a rule that never fires is visible on the entry).
+func replaceBody(rules []*replaceRule, contentType string, body []byte) ([]byte, *int) {
+	var count *int
+	for _, rule := range rules {
+		if !rule.applies(contentType) {
+			continue
+		}
+		if count == nil {
+			count = new(int)
+		}
+		matches := rule.re.FindAllIndex(body, -1)
+		*count += len(matches)
+		if len(matches) == 0 {
+			continue
+		}
+		if rule.literal {
+			body = rule.re.ReplaceAllLiteral(body, rule.replacement)
+		} else {
+			body = rule.re.ReplaceAll(body, rule.replacement)
+		}
+	}
+	return body, count
+}
+
+// decodeContent undoes gzip/deflate content encodings; ok is false for
+// encodings we can't re-create, which are left alone.
+func decodeContent(encoding string, data []byte) (out []byte, ok bool) {
+	var r io.Reader
+	switch strings.ToLower(encoding) {
+	case "", "identity":
+		return data, true
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, false
+		}
+		r = zr
+	case "deflate":
+		// HTTP's deflate is zlib-wrapped (RFC 9110 8.4.1.2), but some
+		// servers send raw deflate, so that is tried too.
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			r = flate.NewReader(bytes.NewReader(data))
+			break
+		}
+		r = zr
+	default:
+		return nil, false
+	}
+	out, err := io.ReadAll(r)
+	return out, err == nil
+}
+
+func encodeContent(encoding string, data []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch strings.ToLower(encoding) {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	default:
+		return data
+	}
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// rewriteBody reads body (up to -max-body), applies rules and returns the new
+// body and its length. Bodies that are too large or use an unsupported
+// encoding are passed through untouched with a nil count.
+func rewriteBody(rules []*replaceRule, header http.Header, body io.ReadCloser) (io.ReadCloser, int64, *int) {
+	limit := maxBody.Load()
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil || int64(len(data)) > limit {
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), body), body}, -1, nil
+	}
+	body.Close()
+	keep := func() (io.ReadCloser, int64, *int) {
+		return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+	}
+	encoding := header.Get("Content-Encoding")
+	plain, ok := decodeContent(encoding, data)
+	if !ok {
+		return keep()
+	}
+	plain, count := replaceBody(rules, header.Get("Content-Type"), plain)
+	if count == nil || *count == 0 {
+		out, n, _ := keep()
+		return out, n, count
+	}
+	data = encodeContent(encoding, plain)
+	header.Set("Content-Length", strconv.Itoa(len(data)))
+	return io.NopCloser(bytes.NewReader(data)), int64(len(data)), count
+}
+
+// applyRequestReplace rewrites the request body before it is captured and
+// forwarded.
+func applyRequestReplace(r *http.Request, info *requestInfo) {
+	if len(reqReplaceRules) == 0 || r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	body, n, count := rewriteBody(reqReplaceRules, r.Header, r.Body)
+	r.Body, info.reqReplacements = body, count
+	if n >= 0 {
+		r.ContentLength = n
+	}
+}
+
+// modifyResponse is the proxies' ModifyResponse: it applies -replace-resp
+// rules and -cors headers, records the exchange, then applies the response
+// header edits so they don't show in history.
+func modifyResponse(resp *http.Response) error {
+	injectCORS(resp)
+	info, _ := resp.Request.Context().Value(reqInfoKey).(*requestInfo)
+	// Chunked bodies are rewritten too, up to -max-body; only event
+	// streams, which never end, are left alone.
+	if len(respReplaceRules) > 0 && info != nil && !isEventStream(resp) {
+		body, n, count := rewriteBody(respReplaceRules, resp.Header, resp.Body)
+		resp.Body, info.respReplacements = body, count
+		if n >= 0 {
+			resp.ContentLength = n
+		}
+	}
+	if info != nil {
+		storeCache(resp, info)
+		storeFixture(resp, info)
+		planHeaderEdits(resp, info)
+	}
+	err := captureResponse(resp)
+	applyHeaderEdits(resp)
+	return err
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replayHeader marks requests re-sent by ProxyEye so their entries are
+// tagged; it is stripped before forwarding.
+const replayHeader = "X-ProxyEye-Replay"
+
+// selfURL is where replays are sent so they flow through the normal
+// proxy pipeline and show up in history.
+var selfURL string
+
+// replayVars are the -replay-vars values for {{name}} placeholders in
+// replayed requests, e.g. a fresh token for a captured login flow. A
+// replay's own vars override them.
+var replayVars = map[string]string{}
+
+var placeholderRe = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// substituteVars replaces the {{name}} placeholders in s that vars has a
+// value for. Others are left as they are: a body may be a template itself.
+func substituteVars(s string, vars map[string]string) string {
+	if len(vars) == 0 || !strings.Contains(s, "{{") {
+		return s
+	}
+	return placeholderRe.ReplaceAllStringFunc(s, func(m string) string {
+		if v, ok := vars[placeholderRe.FindStringSubmatch(m)[1]]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+// withVars is e with vars substituted into its path, query, headers and
+// text body. Binary bodies are sent as captured.
+func withVars(e CombinedLog, vars map[string]string) CombinedLog {
+	e.Path = substituteVars(e.Path, vars)
+	e.OriginalPath = substituteVars(e.OriginalPath, vars)
+	e.QueryString = substituteVars(e.QueryString, vars)
+	e.ReqHeaders = substituteVars(e.ReqHeaders, vars)
+	if e.ReqBodyEncoding == "" {
+		e.ReqBody = substituteVars(e.ReqBody, vars)
+	}
+	return e
+}
+
+// requestVars merges the values for a replay: -replay-vars, then the
+// run's own (body) vars, then ?var.NAME=VALUE query parameters. The query
+// can't use plain names, since ?token= is the inspector token.
+func requestVars(r *http.Request, body map[string]string) map[string]string {
+	vars := maps.Clone(replayVars)
+	maps.Copy(vars, body)
+	for k, v := range r.URL.Query() {
+		if name, ok := strings.CutPrefix(k, "var."); ok && name != "" {
+			vars[name] = v[0]
+		}
+	}
+	return vars
+}
+
+// replayResult describes one replayed request.
+type replayResult struct {
+	Seq     uint64      `json:"seq"`
+	Status  int         `json:"status,omitempty"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+	Latency string      `json:"latency,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	// Diff compares with the captured response (?diff=true).
+	Diff *responseDiff `json:"diff,omitempty"`
+}
+
+// replayRun is a batch replay request for POST /api/replay.
+type replayRun struct {
+	Entries []uint64 `json:"entries"` // seqs
+	Mode    string   `json:"mode"`    // "bulk" (concurrent, default) or "flow" (in order)
+	// CookieJar carries Set-Cookie responses into later requests of the
+	// same run, like a browser would. Off by default: replay is verbatim.
+	CookieJar bool `json:"cookie_jar"`
+	// SeedCookiesFrom preloads the jar with the cookies sent by this entry.
+	SeedCookiesFrom *uint64 `json:"seed_cookies_from,omitempty"`
+	// Vars fill {{name}} placeholders, over -replay-vars.
+	Vars map[string]string `json:"vars,omitempty"`
+}
+
+type jarCookie struct {
+	Host  string `json:"host"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// replayURL is where entry e should be sent: through ProxyEye itself, using
+// the absolute form for entries captured in forward-proxy mode.
+func replayURL(e CombinedLog) *url.URL {
+	u, _ := url.Parse(selfURL)
+	if p := proxyByName[e.Proxy]; p != nil {
+		u, _ = url.Parse(listenerURL(p.ln))
+	}
+	if e.Host != "" {
+		u = &url.URL{Scheme: "http", Host: e.Host}
+	}
+	u.Path, u.RawQuery = clientPath(e), e.QueryString
+	return u
+}
+
+// clientPath is the path e was requested with. Replays go back through the
+// proxy, which applies -rewrite and -target-path again.
+func clientPath(e CombinedLog) string {
+	if e.OriginalPath != "" {
+		return e.OriginalPath
+	}
+	return e.Path
+}
+
+// cookieURL is the URL a client's jar files e's cookies under: it follows
+// the Host header rather than the dialled address.
+func cookieURL(e CombinedLog) *url.URL {
+	u := replayURL(e)
+	if h := parseHeaderDump(e.ReqHeaders).Get("Host"); h != "" {
+		u.Host = h
+	}
+	u.Path, u.RawQuery = "/", ""
+	return u
+}
+
+// newReplayRequest rebuilds the captured request in e.
+func newReplayRequest(e CombinedLog, withJar bool) (*http.Request, error) {
+	body := decodeBody(e.ReqBody, e.ReqBodyEncoding)
+	if e.ReqDecoded != "" {
+		body = encodeContent(e.ReqDecoded, body) // send it compressed, as captured
+	}
+	req, err := http.NewRequest(e.Method, replayURL(e).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range parseHeaderDump(e.ReqHeaders) {
+		switch k {
+		case "Content-Length", "Connection", "Transfer-Encoding", "X-Forwarded-For":
+			continue
+		case "Cookie":
+			if withJar {
+				continue // the jar decides which cookies to send
+			}
+		}
+		req.Header[k] = v
+	}
+	if h := req.Header.Get("Host"); h != "" {
+		req.Host = h
+		req.Header.Del("Host")
+	}
+	req.Header.Del(loopHeader) // set again when ProxyEye forwards it
+	req.Header.Set(replayHeader, "1")
+	return req, nil
+}
+
+func newReplayClient(jar http.CookieJar) *http.Client {
+	self, _ := url.Parse(selfURL)
+	return &http.Client{
+		Jar: jar,
+		// Forward-proxy entries go through ProxyEye as an HTTP proxy.
+		Transport: &http.Transport{Proxy: func(r *http.Request) (*url.URL, error) {
+			if r.URL.Host == self.Host || isNamedProxyHost(r.URL.Host) {
+				return nil, nil
+			}
+			return self, nil
+		}},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// snapshotEntries looks entries up before anything is replayed: replays are
+// added to history, which can evict them once it is full.
+func snapshotEntries(seqs []uint64) []*CombinedLog {
+	out := make([]*CombinedLog, len(seqs))
+	for i, seq := range seqs {
+		if e, ok := historyBySeq(seq); ok {
+			out[i] = &e
+		}
+	}
+	return out
+}
+
+func doReplay(client *http.Client, seq uint64, e *CombinedLog, diff bool, vars map[string]string) replayResult {
+	if e == nil {
+		return replayResult{Seq: seq, Error: "no such history entry"}
+	}
+	res := sendReplay(client, withVars(*e, vars))
+	if diff && res.Error == "" {
+		res.Diff = diffResponses(*e, res)
+	}
+	return res
+}
+
+// sendReplay re-sends e.
+func sendReplay(client *http.Client, e CombinedLog) replayResult {
+	res := replayResult{Seq: e.Seq}
+	req, err := newReplayRequest(e, client.Jar != nil)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	res.Status = resp.StatusCode
+	res.Headers = resp.Header
+	res.Body = string(body)
+	res.Latency = fmt.Sprintf("%.2fms", float64(time.Since(start))/1e6)
+	return res
+}
+
+// seedJar loads the cookies the given entry sent into jar.
+func seedJar(jar *cookiejar.Jar, seq uint64) error {
+	e, ok := historyBySeq(seq)
+	if !ok {
+		return fmt.Errorf("no such history entry %d", seq)
+	}
+	req := http.Request{Header: parseHeaderDump(e.ReqHeaders)}
+	jar.SetCookies(cookieURL(e), req.Cookies())
+	return nil
+}
+
+func jarContents(jar *cookiejar.Jar, seqs []uint64) []jarCookie {
+	cookies := []jarCookie{}
+	seen := map[string]bool{}
+	for _, seq := range seqs {
+		e, ok := historyBySeq(seq)
+		if !ok {
+			continue
+		}
+		u := cookieURL(e)
+		if seen[u.Host] {
+			continue
+		}
+		seen[u.Host] = true
+		for _, c := range jar.Cookies(u) {
+			cookies = append(cookies, jarCookie{u.Host, c.Name, c.Value})
+		}
+	}
+	return cookies
+}
+
+// handleReplay re-sends one captured request (POST /replay/{seq}), with
+// its placeholders filled in, and ?diff=true comparing the new response to
+// the captured one.
+func handleReplay(w http.ResponseWriter, r *http.Request) {
+	seq, err := pathSeq(r)
+	if err != nil {
+		http.Error(w, "invalid seq", http.StatusBadRequest)
+		return
+	}
+	diff := r.URL.Query().Get("diff") == "true"
+	res := doReplay(newReplayClient(nil), seq, snapshotEntries([]uint64{seq})[0], diff, requestVars(r, nil))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// handleReplayRun replays several entries (POST /api/replay[?diff=true]).
+func handleReplayRun(w http.ResponseWriter, r *http.Request) {
+	var run replayRun
+	if err := json.NewDecoder(r.Body).Decode(&run); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if run.Mode == "" {
+		run.Mode = "bulk"
+	}
+	if run.Mode != "bulk" && run.Mode != "flow" {
+		http.Error(w, "mode must be bulk or flow", http.StatusBadRequest)
+		return
+	}
+
+	var jar *cookiejar.Jar
+	var client *http.Client
+	if run.CookieJar {
+		jar, _ = cookiejar.New(nil)
+		if run.SeedCookiesFrom != nil {
+			if err := seedJar(jar, *run.SeedCookiesFrom); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		client = newReplayClient(jar)
+	} else {
+		client = newReplayClient(nil)
+	}
+
+	diff := r.URL.Query().Get("diff") == "true"
+	vars := requestVars(r, run.Vars)
+	entries := snapshotEntries(run.Entries)
+	results := make([]replayResult, len(run.Entries))
+	if run.Mode == "flow" {
+		for i, seq := range run.Entries {
+			results[i] = doReplay(client, seq, entries[i], diff, vars)
+		}
+	} else {
+		var wg sync.WaitGroup
+		for i, seq := range run.Entries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = doReplay(client, seq, entries[i], diff, vars)
+			}()
+		}
+		wg.Wait()
+	}
+
+	out := map[string]any{"results": results}
+	if diff {
+		identical, changed := 0, 0
+		for _, res := range results {
+			if res.Diff == nil {
+				continue
+			} else if res.Diff.Identical {
+				identical++
+			} else {
+				changed++
+			}
+		}
+		out["summary"] = fmt.Sprintf("%d identical, %d changed", identical, changed)
+	}
+	if jar != nil {
+		out["cookies"] = jarContents(jar, run.Entries)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// replayConfig controls whether requests are answered from captured traffic
+// instead of the target ("replay" mode) and how strictly they must match.
+type replayConfig struct {
+	Mode        string   `json:"mode"`        // "proxy" (default) or "replay"
+	Match       []string `json:"match"`       // method, path, query, body, header:Name
+	Fallthrough bool     `json:"fallthrough"` // proxy unmatched requests instead of 501
+}
+
+var (
+	replayMu      sync.Mutex
+	replayCfg     = replayConfig{Mode: "proxy", Match: []string{"method", "path", "query"}}
+	replaySession []CombinedLog // entries loaded with -replay-file
+)
+
+func setReplayConfig(cfg replayConfig) error {
+	if cfg.Mode == "" {
+		cfg.Mode = "proxy"
+	}
+	if cfg.Mode != "proxy" && cfg.Mode != "replay" {
+		return fmt.Errorf("unknown mode %q (want proxy or replay)", cfg.Mode)
+	}
+	if len(cfg.Match) == 0 {
+		cfg.Match = []string{"method", "path", "query"}
+	}
+	for _, m := range cfg.Match {
+		switch {
+		case m == "method", m == "path", m == "query", m == "body":
+		case strings.HasPrefix(m, "header:"):
+		default:
+			return fmt.Errorf("unknown match component %q", m)
+		}
+	}
+	replayMu.Lock()
+	defer replayMu.Unlock()
+	replayCfg = cfg
+	return nil
+}
+
+func getReplayConfig() replayConfig {
+	replayMu.Lock()
+	defer replayMu.Unlock()
+	return replayCfg
+}
+
+// loadReplaySession reads a JSON array of entries, e.g. a saved /history.
+func loadReplaySession(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var entries []CombinedLog
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	replayMu.Lock()
+	defer replayMu.Unlock()
+	replaySession = entries
+	return nil
+}
+
+func canonicalQuery(raw string) string {
+	q, err := url.ParseQuery(raw)
+	if err != nil {
+		return raw
+	}
+	return q.Encode()
+}
+
+func replayMatches(match []string, e *CombinedLog, r *http.Request, body string) bool {
+	if len(namedProxies) > 0 && e.Proxy != requestProxyName(r) {
+		return false // each -proxy answers from its own service's traffic
+	}
+	for _, m := range match {
+		switch m {
+		case "method":
+			if e.Method != r.Method {
+				return false
+			}
+		case "path":
+			if clientPath(*e) != r.URL.Path {
+				return false
+			}
+		case "query":
+			if canonicalQuery(e.QueryString) != canonicalQuery(r.URL.RawQuery) {
+				return false
+			}
+		case "body":
+			got := []byte(body)
+			if e.ReqDecoded != "" {
+				got = plainBody(got, r.Header)
+			}
+			if string(decodeBody(e.ReqBody, e.ReqBodyEncoding)) != string(got) {
+				return false
+			}
+		default:
+			name := strings.TrimPrefix(m, "header:")
+			if parseHeaderDump(e.ReqHeaders).Get(name) != r.Header.Get(name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// findReplay returns the newest captured entry matching r. Only responses
+// that really came from a backend are eligible.
+func findReplay(r *http.Request, body string) (CombinedLog, bool) {
+	cfg := getReplayConfig()
+	historyMutex.Lock()
+	candidates := append([]CombinedLog(nil), history...)
+	historyMutex.Unlock()
+	replayMu.Lock()
+	candidates = append(append([]CombinedLog(nil), replaySession...), candidates...)
+	replayMu.Unlock()
+
+	for i := len(candidates) - 1; i >= 0; i-- {
+		e := &candidates[i]
+		if e.Source != "" || e.InjectedFault != "" || e.RateLimited {
+			continue
+		}
+		if replayMatches(cfg.Match, e, r, body) {
+			return *e, true
+		}
+	}
+	return CombinedLog{}, false
+}
+
+// serveReplay answers r from captured traffic when replay mode is on. It
+// reports whether the request was handled.
+func serveReplay(w http.ResponseWriter, r *http.Request, info *requestInfo, reqBody *bodyCapture) bool {
+	cfg := getReplayConfig()
+	if cfg.Mode != "replay" {
+		return false
+	}
+	// A chunked or large upload is teed as it is forwarded; read it up to
+	// -max-body now so a "body" match sees what history recorded.
+	if slices.Contains(cfg.Match, "body") {
+		reqBody.fill(r)
+	}
+	body, _ := reqBody.snapshot()
+	e, ok := findReplay(r, body)
+	if !ok {
+		if cfg.Fallthrough {
+			return false
+		}
+		info.source = "replay"
+		header := http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+		respondSynthetic(w, r, http.StatusNotImplemented, header, []byte("ProxyEye replay: no recorded response matches this request\n"))
+		return true
+	}
+	header := parseHeaderDump(e.RespHeaders)
+	for _, h := range []string{"Content-Length", "Transfer-Encoding", "Connection"} {
+		header.Del(h)
+	}
+	info.source = "replay"
+	respondSynthetic(w, r, e.Status, header, decodeBody(e.RespBody, e.RespBodyEncoding))
+	return true
+}
+
+func handleModeAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		cfg := getReplayConfig()
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := setReplayConfig(cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(getReplayConfig())
+}
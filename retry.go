@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Retries (-retries) for requests that are safe to repeat: GET, HEAD and
+// OPTIONS are sent again after a connection error or a -retry-status
+// response, waiting -retry-backoff and doubling it each time. Other methods
+// are never retried.
+var (
+	retries      int
+	retryBackoff = 100 * time.Millisecond
+	retryStatus  = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+)
+
+// proxyTransport is the proxies' Transport: upstreamTransport with retries.
+var proxyTransport http.RoundTripper = retryTransport{upstreamTransport}
+
+type retryTransport struct {
+	base http.RoundTripper
+}
+
+func retryable(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		// A body can't be sent twice unless it can be recreated.
+		return r.Body == nil || r.Body == http.NoBody || r.GetBody != nil
+	}
+	return false
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if retries <= 0 || !retryable(req) {
+		return t.base.RoundTrip(req)
+	}
+	info, _ := req.Context().Value(reqInfoKey).(*requestInfo)
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		if info != nil {
+			info.attempts = attempt
+		}
+		resp, err := t.base.RoundTrip(req)
+		var reason string
+		switch {
+		case err != nil && req.Context().Err() == nil:
+			reason = err.Error()
+		case err == nil && slices.Contains(retryStatus, resp.StatusCode):
+			reason = resp.Status
+		}
+		if reason == "" || attempt > retries {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // lets the connection be reused
+			resp.Body.Close()
+		}
+		log.Printf("retry %d/%d for %s %s in %s: %s", attempt, retries, req.Method, req.URL.Path, backoff, reason)
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		backoff *= 2
+	}
+}
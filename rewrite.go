@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// rewriteRule rewrites the path of proxied requests, e.g.
+// "^/v2/users/(\d+)/profile$ => /internal/profiles?user=$1".
+type rewriteRule struct {
+	Name        string
+	re          *regexp.Regexp
+	replacement string
+}
+
+var (
+	rewriteRules []*rewriteRule // first match wins
+	rewriteLog   bool           // record the applied rule on the entry
+
+	setQuery    = url.Values{} // -set-query: added or overridden on every request
+	removeQuery []string       // -remove-query
+)
+
+var rewriteNameRe = regexp.MustCompile(`^([\w.-]+):\s+`)
+
+// parseRewriteRule parses "[NAME: ]PATTERN => REPLACEMENT". Rules without
+// a name are called after their position, e.g. "rewrite-2".
+func parseRewriteRule(spec string, n int) (*rewriteRule, error) {
+	rule := &rewriteRule{Name: fmt.Sprintf("rewrite-%d", n)}
+	if m := rewriteNameRe.FindStringSubmatch(spec); m != nil {
+		rule.Name, spec = m[1], spec[len(m[0]):]
+	}
+	pattern, replacement, ok := strings.Cut(spec, "=>")
+	if !ok {
+		return nil, fmt.Errorf("expected PATTERN => REPLACEMENT in %q", spec)
+	}
+	re, err := regexp.Compile(strings.TrimSpace(pattern))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", strings.TrimSpace(pattern), err)
+	}
+	rule.re, rule.replacement = re, strings.TrimSpace(replacement)
+	return rule, nil
+}
+
+// applyRewrite rewrites the outgoing request's path with the first matching
+// rule. Query parameters produced by the replacement are merged into the
+// request's own, replacing parameters of the same name.
+func applyRewrite(r *http.Request) string {
+	for _, rule := range rewriteRules {
+		m := rule.re.FindStringSubmatchIndex(r.URL.Path)
+		if m == nil {
+			continue
+		}
+		p := r.URL.Path
+		out := p[:m[0]] + string(rule.re.ExpandString(nil, rule.replacement, p, m)) + p[m[1]:]
+		path, query, _ := strings.Cut(out, "?")
+		r.URL.Path, r.URL.RawPath = path, ""
+		if query != "" {
+			merged, _ := url.ParseQuery(r.URL.RawQuery)
+			added, _ := url.ParseQuery(query)
+			for k, v := range added {
+				merged[k] = v
+			}
+			r.URL.RawQuery = merged.Encode()
+		}
+		return rule.Name
+	}
+	return ""
+}
+
+// applyQueryEdits applies -set-query and -remove-query to the outgoing
+// request.
+func applyQueryEdits(r *http.Request) {
+	if len(setQuery) == 0 && len(removeQuery) == 0 {
+		return
+	}
+	q := r.URL.Query()
+	for _, k := range removeQuery {
+		q.Del(k)
+	}
+	for k, v := range setQuery {
+		q[k] = v
+	}
+	r.URL.RawQuery = q.Encode()
+}
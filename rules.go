@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// stringList is a repeatable string flag (e.g. -delay a=1s -delay b=2s).
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ", ") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// routeMatcher matches requests by an optional method and a path regex.
+type routeMatcher struct {
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path"`
+
+	pathRe *regexp.Regexp
+}
+
+func (m *routeMatcher) compile() error {
+	re, err := regexp.Compile(m.Path)
+	if err != nil {
+		return fmt.Errorf("invalid path pattern %q: %v", m.Path, err)
+	}
+	m.Method = strings.ToUpper(m.Method)
+	m.pathRe = re
+	return nil
+}
+
+func (m *routeMatcher) matches(r *http.Request) bool {
+	if m.Method != "" && m.Method != r.Method {
+		return false
+	}
+	return m.pathRe.MatchString(r.URL.Path)
+}
+
+// parseRouteSpec splits a flag value of the form "[METHOD ]PATH=VALUE".
+func parseRouteSpec(spec string) (routeMatcher, string, error) {
+	i := strings.LastIndex(spec, "=")
+	if i <= 0 {
+		return routeMatcher{}, "", fmt.Errorf("expected [METHOD ]PATH=VALUE, got %q", spec)
+	}
+	m := parseRoute(spec[:i])
+	return m, strings.TrimSpace(spec[i+1:]), nil
+}
+
+// parseRoute splits "[METHOD ]PATH" into a routeMatcher (not yet compiled).
+func parseRoute(s string) routeMatcher {
+	s = strings.TrimSpace(s)
+	if method, path, ok := strings.Cut(s, " "); ok && isMethodToken(method) {
+		return routeMatcher{Method: method, Path: strings.TrimSpace(path)}
+	}
+	return routeMatcher{Path: s}
+}
+
+func isMethodToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// -script runs a Starlark file in process instead of calling -hook-url.
+// It may define on_request(req) and on_response(req, resp). Both get dicts
+// ("method", "path", "query", "headers", "body", "truncated"; "status",
+// "headers", "body") and change them in place; on_request may instead
+// return a response dict to answer without contacting the target. The
+// changes become a hookReply, so they apply exactly like a webhook's.
+
+// script is the loaded -script, reloaded when the file changes.
+var script struct {
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	globals starlark.StringDict
+	err     error
+}
+
+// loadScript returns the script's globals, loading it again if the file
+// changed since the last call. A script that fails to load fails every
+// call until it is fixed.
+func loadScript() (starlark.StringDict, error) {
+	st, err := os.Stat(hookScript)
+	if err != nil {
+		return nil, err
+	}
+	script.mu.Lock()
+	defer script.mu.Unlock()
+	if script.modTime.Equal(st.ModTime()) && script.size == st.Size() && (script.globals != nil || script.err != nil) {
+		return script.globals, script.err
+	}
+	script.modTime, script.size = st.ModTime(), st.Size()
+	thread := newScriptThread("load")
+	defer thread.done()
+	script.globals, script.err = starlark.ExecFileOptions(&syntax.FileOptions{}, thread.Thread, hookScript, nil, nil)
+	if script.err == nil && script.globals["on_request"] == nil && script.globals["on_response"] == nil {
+		script.err = fmt.Errorf("%s defines neither on_request nor on_response", hookScript)
+	}
+	if script.err != nil {
+		script.globals = nil
+		log.Printf("-script: %v", script.err)
+	} else {
+		log.Printf("-script: loaded %s", hookScript)
+	}
+	return script.globals, script.err
+}
+
+// scriptThread is a Starlark thread cancelled after -hook-timeout.
+type scriptThread struct {
+	*starlark.Thread
+	timer *time.Timer
+}
+
+func newScriptThread(name string) scriptThread {
+	thread := &starlark.Thread{Name: name, Print: func(_ *starlark.Thread, msg string) {
+		log.Printf("-script: %s", msg)
+	}}
+	timer := time.AfterFunc(hookTimeout, func() {
+		thread.Cancel(fmt.Sprintf("timed out after %v", hookTimeout))
+	})
+	return scriptThread{thread, timer}
+}
+
+func (t scriptThread) done() { t.timer.Stop() }
+
+// runScript calls the script's function for msg's phase and turns what it
+// changed into a reply. A missing function changes nothing.
+func runScript(msg hookMessage) (*hookReply, error) {
+	globals, err := loadScript()
+	if err != nil {
+		return nil, err
+	}
+	reply := &hookReply{}
+	fn, ok := globals["on_"+msg.Phase].(starlark.Callable)
+	if !ok {
+		return reply, nil
+	}
+	req := starRequest(msg.Request)
+	args := starlark.Tuple{req}
+	var resp *starlark.Dict
+	if msg.Response != nil {
+		resp = starDict(map[string]starlark.Value{
+			"status":  starlark.MakeInt(msg.Response.Status),
+			"headers": starHeaders(msg.Response.Headers),
+			"body":    starlark.String(msg.Response.Body),
+		})
+		args = append(args, resp)
+	}
+	thread := newScriptThread(msg.Phase)
+	defer thread.done()
+	ret, err := starlark.Call(thread.Thread, fn, args, nil)
+	if err != nil {
+		if evalErr, ok := err.(*starlark.EvalError); ok {
+			return nil, fmt.Errorf("script failed: %s", evalErr.Backtrace())
+		}
+		return nil, fmt.Errorf("script failed: %v", err)
+	}
+
+	if p, err := requestChanges(msg.Request, req); err != nil {
+		return nil, err
+	} else if p != nil {
+		reply.Request = p
+	}
+	switch {
+	case msg.Response != nil:
+		p, err := responseChanges(msg.Response, resp)
+		if err != nil {
+			return nil, err
+		}
+		reply.Response = p
+	case ret != starlark.None:
+		out, ok := ret.(*starlark.Dict)
+		if !ok {
+			return nil, fmt.Errorf("on_request returned %s, want None or a response dict", ret.Type())
+		}
+		p, err := responseChanges(&hookResponse{}, out)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil { // an empty dict is an empty 200
+			p = &hookReplyResponse{}
+		}
+		reply.Response = p
+	}
+	return reply, nil
+}
+
+func starDict(fields map[string]starlark.Value) *starlark.Dict {
+	d := starlark.NewDict(len(fields))
+	for k, v := range fields {
+		d.SetKey(starlark.String(k), v)
+	}
+	return d
+}
+
+func starRequest(r hookRequest) *starlark.Dict {
+	return starDict(map[string]starlark.Value{
+		"method":    starlark.String(r.Method),
+		"path":      starlark.String(r.Path),
+		"query":     starlark.String(r.Query),
+		"headers":   starHeaders(r.Headers),
+		"body":      starlark.String(r.Body),
+		"truncated": starlark.Bool(r.Truncated),
+	})
+}
+
+// starHeaders maps each header to its value, or a list of values when it
+// has several.
+func starHeaders(h http.Header) *starlark.Dict {
+	d := starlark.NewDict(len(h))
+	for k, vs := range h {
+		if len(vs) == 1 {
+			d.SetKey(starlark.String(k), starlark.String(vs[0]))
+			continue
+		}
+		list := make([]starlark.Value, len(vs))
+		for i, v := range vs {
+			list[i] = starlark.String(v)
+		}
+		d.SetKey(starlark.String(k), starlark.NewList(list))
+	}
+	return d
+}
+
+// stringField reads key from d: nil when it is missing.
+func stringField(d *starlark.Dict, key string) (*string, error) {
+	v, found, _ := d.Get(starlark.String(key))
+	if !found {
+		return nil, nil
+	}
+	s, ok := starlark.AsString(v)
+	if !ok {
+		return nil, fmt.Errorf("script set %s to %s, want a string", key, v.Type())
+	}
+	return &s, nil
+}
+
+// changedString returns the field's new value, or nil if it kept old.
+func changedString(d *starlark.Dict, key, old string) (*string, error) {
+	s, err := stringField(d, key)
+	if err != nil || s == nil || *s == old {
+		return nil, err
+	}
+	return s, nil
+}
+
+// headerChanges diffs the script's headers against old, as a patch for
+// patchHeaders: removed headers get an empty value list.
+func headerChanges(d *starlark.Dict, old http.Header) (http.Header, error) {
+	v, found, _ := d.Get(starlark.String("headers"))
+	if !found {
+		return nil, nil
+	}
+	hd, ok := v.(*starlark.Dict)
+	if !ok {
+		return nil, fmt.Errorf("script set headers to %s, want a dict", v.Type())
+	}
+	patch := http.Header{}
+	seen := map[string]bool{}
+	for _, item := range hd.Items() {
+		name, ok := starlark.AsString(item[0])
+		if !ok {
+			return nil, fmt.Errorf("header name %s is not a string", item[0])
+		}
+		var values []string
+		if s, ok := starlark.AsString(item[1]); ok {
+			values = []string{s}
+		} else if list, ok := item[1].(*starlark.List); ok {
+			for i := range list.Len() {
+				s, ok := starlark.AsString(list.Index(i))
+				if !ok {
+					return nil, fmt.Errorf("header %s: values must be strings", name)
+				}
+				values = append(values, s)
+			}
+		} else {
+			return nil, fmt.Errorf("header %s: want a string or a list of strings, not %s", name, item[1].Type())
+		}
+		seen[http.CanonicalHeaderKey(name)] = true
+		if !slices.Equal(old.Values(name), values) {
+			patch[name] = values
+		}
+	}
+	for name := range old {
+		if !seen[name] {
+			patch[name] = nil
+		}
+	}
+	if len(patch) == 0 {
+		return nil, nil
+	}
+	return patch, nil
+}
+
+func requestChanges(old hookRequest, d *starlark.Dict) (*hookReplyRequest, error) {
+	var p hookReplyRequest
+	var err error
+	if p.Method, err = changedString(d, "method", old.Method); err != nil {
+		return nil, err
+	}
+	if p.Path, err = changedString(d, "path", old.Path); err != nil {
+		return nil, err
+	}
+	if p.Query, err = changedString(d, "query", old.Query); err != nil {
+		return nil, err
+	}
+	if p.Body, err = changedString(d, "body", old.Body); err != nil {
+		return nil, err
+	}
+	if p.Headers, err = headerChanges(d, old.Headers); err != nil {
+		return nil, err
+	}
+	if p.Method == nil && p.Path == nil && p.Query == nil && p.Body == nil && p.Headers == nil {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func responseChanges(old *hookResponse, d *starlark.Dict) (*hookReplyResponse, error) {
+	var p hookReplyResponse
+	if v, found, _ := d.Get(starlark.String("status")); found {
+		status, err := starlark.AsInt32(v)
+		if err != nil || status < 100 || status > 999 {
+			return nil, fmt.Errorf("script set status to %s, want a status code", v)
+		}
+		if status != old.Status {
+			p.Status = status
+		}
+	}
+	var err error
+	if p.Body, err = changedString(d, "body", old.Body); err != nil {
+		return nil, err
+	}
+	if p.Headers, err = headerChanges(d, old.Headers); err != nil {
+		return nil, err
+	}
+	if p.Status == 0 && p.Body == nil && p.Headers == nil {
+		return nil, nil
+	}
+	return &p, nil
+}
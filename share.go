@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Share links (POST /api/share) hand the inspector to someone else for a
+// while: the token is read-only whatever -token allows, expires on its own
+// and can be revoked early. A share with a filter only sees matching
+// entries, and only on the routes that apply the filter (sharedRoutes).
+type shareGrant struct {
+	ID      string    `json:"id"`
+	Label   string    `json:"label,omitempty"`
+	Filter  *wsFilter `json:"filter,omitempty"`
+	Created string    `json:"created"`
+	Expires string    `json:"expires"`
+
+	expires time.Time
+	timer   *time.Timer
+}
+
+const (
+	shareTokenPrefix = "pes_"
+	defaultShareTTL  = time.Hour
+	maxShareTTL      = 7 * 24 * time.Hour
+)
+
+var (
+	sharesMu sync.Mutex
+	shares   = map[string]*shareGrant{}
+
+	// shareSecret signs share tokens. It is per run, so a restart
+	// invalidates every link.
+	shareSecret = func() []byte {
+		b := make([]byte, 32)
+		rand.Read(b)
+		return b
+	}()
+)
+
+// sharedRoutes are what a filtered share may use: each one applies the
+// share's filter, unlike, say, /export/postman.
+var sharedRoutes = []string{
+	"/inspect", "/ws", "GET /stream", "GET /poll", "/history", "GET /history/{seq}",
+	"GET /api/status", "GET /__proxyeye/version",
+}
+
+type shareCtxKey struct{}
+
+// requestShare is the share r was authorized with, or nil.
+func requestShare(r *http.Request) *shareGrant {
+	g, _ := r.Context().Value(shareCtxKey{}).(*shareGrant)
+	return g
+}
+
+// filter is what g limits its holder to: nil (everything) for a nil g.
+func (g *shareGrant) filter() *wsFilter {
+	if g == nil {
+		return nil
+	}
+	return g.Filter
+}
+
+// shareToken is "pes_ID.EXPIRY.SIG", with SIG an HMAC over ID.EXPIRY.
+func shareToken(id string, expires time.Time) string {
+	payload := id + "." + strconv.FormatInt(expires.Unix(), 10)
+	return shareTokenPrefix + payload + "." + shareSignature(payload)
+}
+
+func shareSignature(payload string) string {
+	mac := hmac.New(sha256.New, shareSecret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// lookupShare checks a share token's signature and expiry, and that it
+// hasn't been revoked.
+func lookupShare(token string) (*shareGrant, error) {
+	parts := strings.Split(strings.TrimPrefix(token, shareTokenPrefix), ".")
+	if len(parts) != 3 || !hmac.Equal([]byte(parts[2]), []byte(shareSignature(parts[0]+"."+parts[1]))) {
+		return nil, errors.New("invalid share token")
+	}
+	sharesMu.Lock()
+	g := shares[parts[0]]
+	sharesMu.Unlock()
+	if g == nil || !time.Now().Before(g.expires) {
+		return nil, errors.New("share link expired or revoked")
+	}
+	return g, nil
+}
+
+// authorizeShare decides what a share token may do: read-only requests,
+// never the share API itself, the client list or /debug, and for filtered
+// shares only sharedRoutes.
+func authorizeShare(mux *http.ServeMux, r *http.Request, g *shareGrant) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	_, pattern := mux.Handler(r)
+	if strings.Contains(pattern, "/api/share") || strings.Contains(pattern, "/api/clients") || strings.Contains(pattern, "/debug/") {
+		return false
+	}
+	return g.Filter == nil || slices.Contains(sharedRoutes, pattern)
+}
+
+// withShare attaches g to r for the handlers that apply its filter.
+func withShare(r *http.Request, g *shareGrant) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), shareCtxKey{}, g))
+}
+
+// handleShareAPI lists active shares (GET /api/share) or creates one
+// (POST {"ttl": "1h", "label": "...", "filter": {...}}). Only the creation
+// response includes the token.
+func handleShareAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		sharesMu.Lock()
+		list := make([]*shareGrant, 0, len(shares))
+		for _, g := range shares {
+			list = append(list, g)
+		}
+		sharesMu.Unlock()
+		slices.SortFunc(list, func(a, b *shareGrant) int { return a.expires.Compare(b.expires) })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	case http.MethodPost:
+		var req struct {
+			TTL    string    `json:"ttl"`
+			Label  string    `json:"label"`
+			Filter *wsFilter `json:"filter"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ttl := defaultShareTTL
+		if req.TTL != "" {
+			var err error
+			if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 || ttl > maxShareTTL {
+				http.Error(w, fmt.Sprintf("ttl must be a positive duration up to %s", maxShareTTL), http.StatusBadRequest)
+				return
+			}
+		}
+		filter, err := req.Filter.compile()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		g := &shareGrant{
+			ID: generateToken()[:12], Label: req.Label, Filter: filter,
+			Created: now.Format(time.RFC3339), Expires: now.Add(ttl).Format(time.RFC3339),
+			expires: now.Add(ttl),
+		}
+		g.timer = time.AfterFunc(ttl, func() { revokeShare(g.ID, "share link expired") })
+		sharesMu.Lock()
+		shares[g.ID] = g
+		sharesMu.Unlock()
+
+		token := shareToken(g.ID, g.expires)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct {
+			*shareGrant
+			Token string `json:"token"`
+			URL   string `json:"url"`
+		}{g, token, selfURL + "/inspect?token=" + url.QueryEscape(token)})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleShare serves GET and DELETE /api/share/{id}. Revoking a share also
+// disconnects the websockets opened with it.
+func handleShare(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method == http.MethodDelete {
+		if !revokeShare(id, "share link revoked") {
+			http.Error(w, "no such share", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	sharesMu.Lock()
+	g := shares[id]
+	sharesMu.Unlock()
+	if g == nil {
+		http.Error(w, "no such share", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(g)
+}
+
+// revokeShare forgets share id, closes its websockets with 1008 (policy
+// violation) and reason, and ends its /stream responses. It reports
+// whether the share existed.
+func revokeShare(id, reason string) bool {
+	sharesMu.Lock()
+	g := shares[id]
+	delete(shares, id)
+	sharesMu.Unlock()
+	if g == nil {
+		return false
+	}
+	g.timer.Stop()
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for c := range clients {
+		if c.share == g {
+			c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			c.conn.Close()
+		}
+	}
+	for s := range streams {
+		if s.share == g {
+			delete(streams, s)
+			close(s.send)
+		}
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// saveFile receives the history as JSON (loadable with -replay-file) on
+// shutdown and every -snapshot-interval.
+var saveFile string
+
+// saveHistory writes history to saveFile via a temporary file and rename,
+// so a crash mid-write never leaves a truncated file behind.
+func saveHistory() error {
+	historyMutex.Lock()
+	data, err := json.Marshal(history)
+	historyMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(saveFile), filepath.Base(saveFile)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), saveFile)
+}
+
+// startSnapshots saves history every interval; watchShutdown saves it once
+// more on exit.
+func startSnapshots(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if err := saveHistory(); err != nil {
+				log.Printf("-save: %v", err)
+			}
+		}
+	}()
+}
+
+// watchShutdown handles SIGINT/SIGTERM.
+func watchShutdown() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		shutdown()
+	}()
+}
+
+// shutdownGrace is how long shutdown waits for requests in flight, such as
+// a proxied event stream, before cutting their connections.
+const shutdownGrace = 5 * time.Second
+
+var (
+	shutdownOnce sync.Once
+
+	// stopping is closed when shutdown starts, to end the inspector's own
+	// long-lived responses (/stream, /poll).
+	stopping = make(chan struct{})
+
+	stopBroadcasts = make(chan struct{})
+	broadcastsDone = make(chan struct{})
+
+	serversMu sync.Mutex
+	servers   []*http.Server
+)
+
+// serve serves h on ln until shutdown stops it.
+func serve(ln net.Listener, h http.Handler) {
+	srv := &http.Server{Handler: h}
+	serversMu.Lock()
+	servers = append(servers, srv)
+	serversMu.Unlock()
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	select {} // shutdown exits once it has saved
+}
+
+// stopServers closes the listeners and waits up to shutdownGrace for
+// requests in flight, so their entries are recorded.
+func stopServers() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+	serversMu.Lock()
+	list := servers
+	serversMu.Unlock()
+	var wg sync.WaitGroup
+	for _, srv := range list {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if srv.Shutdown(ctx) != nil {
+				srv.Close()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// drainBroadcasts stops the broadcast loop and delivers what is still
+// queued, so it reaches history before -save.
+func drainBroadcasts() {
+	close(stopBroadcasts)
+	<-broadcastsDone
+	for {
+		select {
+		case msg := <-broadcast:
+			deliverEntry(msg)
+		default:
+			return
+		}
+	}
+}
+
+// shutdown stops the servers, writes -save, prints the session summary and
+// exits. It runs on SIGINT/SIGTERM, or when q is pressed in -tui.
+func shutdown() {
+	shutdownOnce.Do(func() {
+		restoreTUI()
+		serverState.Store(stateStopping)
+		closeClients(websocket.CloseGoingAway, "ProxyEye is shutting down")
+		close(stopping)
+		stopServers()
+		drainBroadcasts()
+		if saveFile != "" {
+			if err := saveHistory(); err != nil {
+				log.Printf("-save: %v", err)
+			}
+		}
+		if accessLog != nil {
+			accessLog.flush()
+		}
+		printSummary(os.Stderr)
+		os.Exit(0)
+	})
+	select {} // the first caller exits
+}
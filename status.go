@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Server states for GET /api/status: only "ready" answers 200, so scripts
+// can wait on it with curl --fail.
+const (
+	stateStarting int32 = iota
+	stateReady
+	stateStopping
+)
+
+var (
+	serverState atomic.Int32
+	stateNames  = [...]string{"starting", "ready", "shutting_down"}
+
+	listenAddrs map[string]string // set once the listeners are bound
+)
+
+// healthInterval is how often the target is checked: a TCP connect, so
+// it never shows up in history or reaches the target's handlers.
+const healthInterval = 10 * time.Second
+
+type healthCheck struct {
+	OK        bool    `json:"ok"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMs float64 `json:"latency_ms"`
+	CheckedAt string  `json:"checked_at"`
+}
+
+var (
+	healthMu   sync.Mutex
+	lastHealth *healthCheck // nil until the first check finishes
+)
+
+// startHealthChecks dials target now and every healthInterval.
+func startHealthChecks(target *url.URL) {
+	addr := target.Host
+	if target.Port() == "" {
+		port := "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(target.Hostname(), port)
+	}
+	go func() {
+		for {
+			start := time.Now()
+			c := &healthCheck{CheckedAt: start.Format(time.RFC3339)}
+			conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+			c.LatencyMs = float64(time.Since(start).Microseconds()) / 1e3
+			if err != nil {
+				c.Error = err.Error()
+			} else {
+				c.OK = true
+				conn.Close()
+			}
+			healthMu.Lock()
+			lastHealth = c
+			healthMu.Unlock()
+			time.Sleep(healthInterval)
+		}
+	}()
+}
+
+// historyBytes approximates the memory history holds: its bodies and
+// header dumps.
+func historyBytes() (entries, limit int, size int64) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	for _, e := range history {
+		size += int64(len(e.ReqHeaders) + len(e.ReqBody) + len(e.RespHeaders) + len(e.RespBody) + len(e.Note))
+	}
+	return len(history), maxHistory, size
+}
+
+// handleStatusAPI reports the proxy's runtime state (GET /api/status). It
+// answers 503 until every listener is up and again once shutdown begins.
+func handleStatusAPI(w http.ResponseWriter, r *http.Request) {
+	state := serverState.Load()
+	healthMu.Lock()
+	health := lastHealth
+	healthMu.Unlock()
+	entries, limit, size := historyBytes()
+	proxies := map[string]string{}
+	for _, p := range namedProxies {
+		proxies[p.Name] = listenerURL(p.ln)
+	}
+	uptime := time.Since(serverStarted).Round(time.Second)
+
+	w.Header().Set("Content-Type", "application/json")
+	if state != stateReady {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":               stateNames[state],
+		"version":              buildVersion().Version,
+		"started":              serverStarted.Format(time.RFC3339),
+		"uptime":               uptime.String(),
+		"uptime_seconds":       int64(uptime.Seconds()),
+		"target":               fixedConfig["target"],
+		"target_health":        health,
+		"listen":               listenAddrs,
+		"proxies":              proxies,
+		"history":              map[string]any{"entries": entries, "max": limit, "bytes": size},
+		"read_only":            readOnly,
+		"debug":                debugEnabled,
+		"ws_clients":           clientCount(),
+		"capture_paused":       capturePaused.Load(),
+		"skipped_while_paused": pausedCount.Load(),
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// streamClient is a GET /stream reader: entries as NDJSON over plain HTTP,
+// for curl -N | jq. It is fed by publishEntry alongside the websocket
+// clients.
+type streamClient struct {
+	clientStats
+	send    chan []byte
+	dropped atomic.Int64 // lines discarded since the last one written
+	filter  *wsFilter
+	share   *shareGrant
+}
+
+// streams are guarded by clientsMu, like clients.
+var streams = map[*streamClient]bool{}
+
+// streamKeepalive is how often an idle stream gets an empty line, so dead
+// connections are noticed. NDJSON readers skip blank lines.
+const streamKeepalive = 15 * time.Second
+
+// publishToStreams queues e for every stream that wants it. Callers hold
+// clientsMu.
+func publishToStreams(e *CombinedLog) {
+	var line []byte
+	for s := range streams {
+		if !s.share.filter().matches(e) || !s.filter.matches(e) {
+			continue
+		}
+		if line == nil {
+			line, _ = json.Marshal(e)
+			line = append(line, '\n')
+		}
+		select {
+		case s.send <- line:
+		default:
+			s.dropped.Add(1)
+			s.lost.Add(1)
+		}
+	}
+}
+
+// handleStream serves GET /stream: each new entry as one JSON line, until
+// the client disconnects. The query takes the websocket filter fields:
+// method and proxy and tag (comma-separated), path_regex and min_status.
+// A {"type":"dropped","count":N} line stands in for entries a slow reader
+// missed.
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := &wsFilter{PathRegex: q.Get("path_regex")}
+	for name, dst := range map[string]*[]string{"method": &f.Method, "proxy": &f.Proxy, "tag": &f.Tag} {
+		if v := q.Get(name); v != "" {
+			*dst = strings.Split(v, ",")
+		}
+	}
+	if v := q.Get("min_status"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "min_status must be an integer", http.StatusBadRequest)
+			return
+		}
+		f.MinStatus = n
+	}
+	filter, err := f.compile()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s := &streamClient{clientStats: newClientStats(r), send: make(chan []byte, wsSendBuffer), filter: filter, share: requestShare(r)}
+	clientsMu.Lock()
+	streams[s] = true
+	clientsMu.Unlock()
+	defer func() {
+		clientsMu.Lock()
+		delete(streams, s)
+		clientsMu.Unlock()
+	}()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	t := time.NewTicker(streamKeepalive)
+	defer t.Stop()
+	for {
+		var line []byte
+		select {
+		case l, ok := <-s.send:
+			if !ok {
+				return // share revoked, or disconnected via /api/clients
+			}
+			line = l
+			if n := s.dropped.Swap(0); n > 0 {
+				note, _ := json.Marshal(map[string]any{"type": "dropped", "count": n})
+				line = append(append(note, '\n'), line...)
+			}
+		case <-t.C:
+			line = []byte("\n")
+		case <-r.Context().Done():
+			return
+		case <-stopping:
+			return
+		}
+		n, err := w.Write(line)
+		s.bytes.Add(int64(n))
+		s.wire.Add(int64(n))
+		if err != nil || rc.Flush() != nil {
+			return
+		}
+		if len(line) > 1 {
+			s.sent.Add(1)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// printSummary writes a short report of the session to w on exit. The
+// breakdown covers the entries still in history (the last maxHistory).
+func printSummary(w io.Writer) {
+	historyMutex.Lock()
+	entries := append([]CombinedLog(nil), history...)
+	historyMutex.Unlock()
+
+	total := capturedCount.Load()
+	fmt.Fprintf(w, "\nSession summary: %d requests captured", total)
+	if int64(len(entries)) < total {
+		fmt.Fprintf(w, " (breakdown of the last %d)", len(entries))
+	}
+	fmt.Fprintln(w)
+	if len(entries) == 0 {
+		return
+	}
+
+	var classes [6]int // index 1-5 = 1xx-5xx, 0 = anything else
+	errors := 0
+	var slowest *CombinedLog
+	var slowestMs float64
+	for i := range entries {
+		e := &entries[i]
+		if c := e.Status / 100; c >= 1 && c <= 5 {
+			classes[c]++
+		} else {
+			classes[0]++
+		}
+		if e.Status >= 400 {
+			errors++
+		}
+		if ms, err := strconv.ParseFloat(strings.TrimSuffix(e.Latency, "ms"), 64); err == nil && (slowest == nil || ms > slowestMs) {
+			slowest, slowestMs = e, ms
+		}
+	}
+	var parts []string
+	for c := 1; c <= 5; c++ {
+		if classes[c] > 0 {
+			parts = append(parts, colorize(statusColor(c*100), fmt.Sprintf("%dxx: %d", c, classes[c])))
+		}
+	}
+	if classes[0] > 0 {
+		parts = append(parts, fmt.Sprintf("other: %d", classes[0]))
+	}
+	fmt.Fprintf(w, "  Status:  %s\n", strings.Join(parts, "  "))
+	fmt.Fprintf(w, "  Errors:  %d\n", errors)
+	if slowest != nil {
+		fmt.Fprintf(w, "  Slowest: %s %s (%d, %s)\n", slowest.Method, slowest.Path, slowest.Status, slowest.Latency)
+	}
+}
+
+// pathNormalizers are the -normalize rules. Stats group entries by their
+// normalized path, so /users/1 and /users/2 both count as /users/:id;
+// entries keep the path as requested.
+var pathNormalizers []pathNormalizer
+
+type pathNormalizer struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// parseNormalize parses "PATTERN => REPLACEMENT", like -rewrite.
+func parseNormalize(spec string) (pathNormalizer, error) {
+	pattern, replacement, ok := strings.Cut(spec, "=>")
+	if !ok {
+		return pathNormalizer{}, fmt.Errorf("expected PATTERN => REPLACEMENT in %q", spec)
+	}
+	re, err := regexp.Compile(strings.TrimSpace(pattern))
+	if err != nil {
+		return pathNormalizer{}, fmt.Errorf("invalid pattern %q: %v", strings.TrimSpace(pattern), err)
+	}
+	return pathNormalizer{re, strings.TrimSpace(replacement)}, nil
+}
+
+// statsPath is path as stats group it: every -normalize rule applied in
+// turn, each to all its matches.
+func statsPath(path string) string {
+	for _, n := range pathNormalizers {
+		path = n.re.ReplaceAllString(path, n.replacement)
+	}
+	return path
+}
+
+// largestPaths is how many paths /api/stats lists by response size.
+const largestPaths = 10
+
+type sizeStats struct {
+	Entries          int         `json:"entries"` // in history, which the sizes cover
+	ReqBytes         byteTotals  `json:"req_bytes"`
+	RespBytes        byteTotals  `json:"resp_bytes"`
+	LargestResponses []pathSizes `json:"largest_responses"`
+}
+
+type byteTotals struct {
+	Total   int64 `json:"total"`
+	Average int64 `json:"average"`
+}
+
+type pathSizes struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	Count       int    `json:"count"`
+	RespTotal   int64  `json:"resp_total"`
+	RespAverage int64  `json:"resp_average"`
+	RespMax     int64  `json:"resp_max"`
+	ReqAverage  int64  `json:"req_average"`
+
+	reqTotal int64
+}
+
+// bodySizeStats totals the wire sizes of entries and ranks method+path
+// (normalized) by average response size.
+func bodySizeStats(entries []CombinedLog) sizeStats {
+	s := sizeStats{Entries: len(entries), LargestResponses: []pathSizes{}}
+	byPath := map[[2]string]*pathSizes{}
+	for _, e := range entries {
+		s.ReqBytes.Total += e.ReqBytes
+		s.RespBytes.Total += e.RespBytes
+		k := [2]string{e.Method, statsPath(e.Path)}
+		p := byPath[k]
+		if p == nil {
+			p = &pathSizes{Method: k[0], Path: k[1]}
+			byPath[k] = p
+		}
+		p.Count++
+		p.RespTotal += e.RespBytes
+		p.RespMax = max(p.RespMax, e.RespBytes)
+		p.reqTotal += e.ReqBytes
+	}
+	if n := int64(len(entries)); n > 0 {
+		s.ReqBytes.Average = s.ReqBytes.Total / n
+		s.RespBytes.Average = s.RespBytes.Total / n
+	}
+	for _, p := range byPath {
+		p.RespAverage = p.RespTotal / int64(p.Count)
+		p.ReqAverage = p.reqTotal / int64(p.Count)
+		s.LargestResponses = append(s.LargestResponses, *p)
+	}
+	slices.SortFunc(s.LargestResponses, func(a, b pathSizes) int {
+		return cmp.Or(cmp.Compare(b.RespAverage, a.RespAverage), cmp.Compare(b.RespMax, a.RespMax),
+			cmp.Compare(a.Path, b.Path), cmp.Compare(a.Method, b.Method))
+	})
+	if len(s.LargestResponses) > largestPaths {
+		s.LargestResponses = s.LargestResponses[:largestPaths]
+	}
+	return s
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// targetHeader sends a single request to another backend, e.g. to compare
+// two versions of a service from one client. It only has an effect with
+// -allow-dynamic-target, and is stripped before forwarding.
+const targetHeader = "X-ProxyEye-Target"
+
+var allowDynamicTarget bool
+
+// proxyDirector wraps a reverse proxy's base Director with ProxyEye's
+// request edits (loop marker, -rewrite, -set-query/-remove-query). The
+// edits see the client's path; base then joins the target's path
+// (-target-path) in front of the result.
+func proxyDirector(base func(*http.Request)) func(*http.Request) {
+	return func(r *http.Request) {
+		if name := applyRewrite(r); name != "" && rewriteLog {
+			if info, _ := r.Context().Value(reqInfoKey).(*requestInfo); info != nil {
+				info.rewrite = name
+			}
+		}
+		applyQueryEdits(r)
+		base(r)
+		markForwarded(r)
+		r.RequestURI = "" // so the captured request line shows the edited URL
+	}
+}
+
+// dynamicProxy returns a one-off copy of upstream aimed at target.
+func dynamicProxy(upstream http.Handler, target string) (http.Handler, error) {
+	base, ok := upstream.(*httputil.ReverseProxy)
+	if !ok {
+		return nil, fmt.Errorf("%s is not supported here", targetHeader)
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s must be an http(s) URL such as http://localhost:9000, got %q", targetHeader, target)
+	}
+	p := *base
+	p.Director = proxyDirector(httputil.NewSingleHostReverseProxy(u).Director)
+	return &p, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// -rewrite rules match the client's path; -target-path goes in front of
+// the rewritten path, and -set-query applies on top.
+func TestRewriteWithTargetPath(t *testing.T) {
+	rule, err := parseRewriteRule(`^/old/(.*) => /new/$1`, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewriteRules, setQuery = []*rewriteRule{rule}, url.Values{"debug": {"true"}}
+	t.Cleanup(func() {
+		rewriteRules, setQuery = nil, url.Values{}
+	})
+	runBroadcaster(t)
+
+	got := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.URL.RequestURI()
+	}))
+	defer backend.Close()
+	front := newTestProxy(t, backend.URL+"/api/v2")
+
+	for path, want := range map[string]string{
+		"/old/x?a=1": "/api/v2/new/x?a=1&debug=true",
+		"/other":     "/api/v2/other?debug=true",
+	} {
+		resp, err := http.Get(front.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if uri := <-got; uri != want {
+			t.Errorf("%s reached the target as %s, want %s", path, uri, want)
+		}
+	}
+}
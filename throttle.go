@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// throttleHeader lets a client override the bandwidth for one request.
+const throttleHeader = "X-ProxyEye-Throttle"
+
+// Default bandwidth limits in bits per second (0 = unlimited).
+var throttleUp, throttleDown float64
+
+// parseBandwidth parses "256kbps", "1.5mbps", "9600bps" or "" (unlimited)
+// into bits per second.
+func parseBandwidth(s string) (float64, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	if v == "" {
+		return 0, nil
+	}
+	mult := 1.0
+	switch {
+	case strings.HasSuffix(v, "gbps"):
+		mult, v = 1e9, strings.TrimSuffix(v, "gbps")
+	case strings.HasSuffix(v, "mbps"):
+		mult, v = 1e6, strings.TrimSuffix(v, "mbps")
+	case strings.HasSuffix(v, "kbps"):
+		mult, v = 1e3, strings.TrimSuffix(v, "kbps")
+	default:
+		v = strings.TrimSuffix(v, "bps")
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q", s)
+	}
+	return n * mult, nil
+}
+
+func formatBandwidth(bps float64) string {
+	switch {
+	case bps >= 1e6:
+		return strconv.FormatFloat(bps/1e6, 'f', -1, 64) + "mbps"
+	case bps >= 1e3:
+		return strconv.FormatFloat(bps/1e3, 'f', -1, 64) + "kbps"
+	}
+	return strconv.FormatFloat(bps, 'f', -1, 64) + "bps"
+}
+
+// pacer sleeps as needed to keep a byte stream at a fixed bit rate.
+type pacer struct {
+	bytesPerSec float64
+	start       time.Time
+	sent        int64
+}
+
+func newPacer(bps float64) *pacer {
+	return &pacer{bytesPerSec: bps / 8, start: time.Now()}
+}
+
+// chunk is the slice size that keeps pacing smooth (~100ms of data).
+func (p *pacer) chunk() int {
+	return max(1, int(p.bytesPerSec/10))
+}
+
+func (p *pacer) pace(n int) {
+	p.sent += int64(n)
+	due := p.start.Add(time.Duration(float64(p.sent) / p.bytesPerSec * float64(time.Second)))
+	time.Sleep(time.Until(due))
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	p *pacer
+}
+
+func (t *throttledReader) Read(b []byte) (int, error) {
+	if len(b) > t.p.chunk() {
+		b = b[:t.p.chunk()]
+	}
+	n, err := t.ReadCloser.Read(b)
+	t.p.pace(n)
+	return n, err
+}
+
+// throttledWriter paces response bytes, flushing each slice so streaming
+// responses still arrive incrementally.
+type throttledWriter struct {
+	http.ResponseWriter
+	p *pacer
+}
+
+func (t *throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), t.p.chunk())
+		t.p.pace(n)
+		m, err := t.ResponseWriter.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		http.NewResponseController(t.ResponseWriter).Flush()
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (t *throttledWriter) Unwrap() http.ResponseWriter { return t.ResponseWriter }
+
+// applyThrottle wraps the request body and response writer according to the
+// global limits or the per-request override header.
+func applyThrottle(w http.ResponseWriter, r *http.Request, info *requestInfo) (http.ResponseWriter, error) {
+	up, down := throttleUp, throttleDown
+	if v := r.Header.Get(throttleHeader); v != "" {
+		r.Header.Del(throttleHeader)
+		bps, err := parseBandwidth(v)
+		if err != nil {
+			return w, err
+		}
+		up, down = bps, bps
+	}
+	var notes []string
+	if up > 0 && r.Body != nil && r.Body != http.NoBody {
+		r.Body = &throttledReader{ReadCloser: r.Body, p: newPacer(up)}
+		notes = append(notes, "up "+formatBandwidth(up))
+	}
+	if down > 0 {
+		w = &throttledWriter{ResponseWriter: w, p: newPacer(down)}
+		notes = append(notes, "down "+formatBandwidth(down))
+	}
+	info.throttle = strings.Join(notes, ", ")
+	return w, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// timelineItem is one bar of the request waterfall. Times are epoch ms;
+// Offset is from the earliest start in the response.
+type timelineItem struct {
+	Seq      uint64 `json:"seq"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Status   int    `json:"status"`
+	Start    int64  `json:"start"`
+	End      int64  `json:"end"`
+	Duration int64  `json:"duration_ms"`
+	Offset   int64  `json:"offset_ms"`
+}
+
+// handleTimeline serves GET /stats/timeline: history ordered by start time,
+// for a Gantt-style view of overlapping requests.
+func handleTimeline(w http.ResponseWriter, r *http.Request) {
+	historyMutex.Lock()
+	items := make([]timelineItem, 0, len(history))
+	for _, e := range history {
+		items = append(items, timelineItem{
+			Seq: e.Seq, Method: e.Method, Path: e.Path, Status: e.Status,
+			Start: e.StartTime, End: e.EndTime, Duration: e.EndTime - e.StartTime,
+		})
+	}
+	historyMutex.Unlock()
+
+	slices.SortStableFunc(items, func(a, b timelineItem) int { return cmp.Compare(a.Start, b.Start) })
+	for i := range items {
+		items[i].Offset = items[i].Start - items[0].Start
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
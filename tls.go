@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// upstreamTransport carries every proxied request, so -min-tls and
+// -insecure-skip-verify apply to all HTTPS backends.
+var upstreamTransport = http.DefaultTransport.(*http.Transport).Clone()
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// configureUpstreamTLS applies -min-tls ("" = Go's default) and
+// -insecure-skip-verify.
+func configureUpstreamTLS(minVersion string, insecure bool) error {
+	cfg := &tls.Config{InsecureSkipVerify: insecure}
+	if minVersion != "" {
+		v, ok := tlsVersions[minVersion]
+		if !ok {
+			return fmt.Errorf("unknown TLS version %q (want 1.0, 1.1, 1.2 or 1.3)", minVersion)
+		}
+		cfg.MinVersion = v
+	}
+	upstreamTransport.TLSClientConfig = cfg
+	return nil
+}
+
+func isTLSError(err error) bool {
+	var (
+		header   tls.RecordHeaderError
+		alert    tls.AlertError
+		verify   *tls.CertificateVerificationError
+		unknown  x509.UnknownAuthorityError
+		hostname x509.HostnameError
+		invalid  x509.CertificateInvalidError
+	)
+	return errors.As(err, &header) || errors.As(err, &alert) || errors.As(err, &verify) ||
+		errors.As(err, &unknown) || errors.As(err, &hostname) || errors.As(err, &invalid) ||
+		strings.Contains(err.Error(), "tls: ")
+}
+
+// proxyErrorHandler is the proxies' ErrorHandler: the standard 502, with
+// TLS handshake failures called out so -min-tls problems are obvious.
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	upstreamErrors.Add(1)
+	if info, ok := r.Context().Value(reqInfoKey).(*requestInfo); ok {
+		info.upstreamErr = err.Error()
+	}
+	if isTLSError(err) {
+		log.Printf("TLS handshake with %s failed: %v", r.URL.Host, err)
+	} else {
+		log.Printf("http: proxy error: %v", err)
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// -tui replaces the scrolling CLI dashboard with an interactive one: a
+// request list you can scroll back through and a pane with the selected
+// entry's headers and bodies. Bubble Tea drives the terminal: raw mode,
+// the alternate screen, and resizes as they happen.
+
+// tuiScrollback is how many entries the list keeps. History may keep
+// fewer; the list isn't trimmed when it does.
+const tuiScrollback = 1000
+
+var (
+	tuiMu sync.Mutex
+	// tuiRestore puts the terminal back the way it was. Set while -tui or
+	// -cli-mode dashboard runs.
+	tuiRestore func()
+)
+
+// restoreTUI leaves the TUI or dashboard screen, if it is showing, so that output
+// printed on the way out (the session summary) lands in the terminal.
+func restoreTUI() {
+	tuiMu.Lock()
+	defer tuiMu.Unlock()
+	if tuiRestore != nil {
+		tuiRestore()
+		tuiRestore = nil
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// startTUI runs -tui. Without a terminal to draw on (output piped to a
+// file), it falls back to the plain dashboard.
+func startTUI(target, targetURL, customDomain string) {
+	if !isTerminal(os.Stdin) || !ansiOut {
+		startCLIDashboard(target, targetURL, customDomain)
+		return
+	}
+	t := &tui{header: fmt.Sprintf("Domain: %s | Forwarding: %s", customDomain, targetURL), follow: true, rows: 24, cols: 80}
+	historyMutex.Lock()
+	backfill := history[max(0, len(history)-tuiScrollback):]
+	for _, e := range backfill {
+		t.add(e)
+	}
+	historyMutex.Unlock()
+
+	// SIGINT and SIGTERM are left to watchShutdown, which saves and exits.
+	p := tea.NewProgram(t, tea.WithAltScreen(), tea.WithoutSignalHandler())
+	done := make(chan struct{})
+	log.SetOutput(&t.logs) // stderr would scribble over the screen
+	tuiMu.Lock()
+	tuiRestore = func() {
+		p.Quit()
+		<-done
+		log.SetOutput(os.Stderr)
+	}
+	tuiMu.Unlock()
+	_, err := p.Run()
+	close(done)
+	if err != nil {
+		restoreTUI()
+		log.Printf("-tui: %v; using the plain dashboard", err)
+		startCLIDashboard(target, targetURL, customDomain)
+		return
+	}
+	shutdown() // q or Ctrl+C
+}
+
+// tuiEntry carries an entry from cliChan into the program; tuiTick
+// refreshes the status line and the footer's last log line.
+type (
+	tuiEntry CombinedLog
+	tuiTick  struct{}
+)
+
+func waitEntry() tea.Msg { return tuiEntry(<-cliChan) }
+
+func tickTUI() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg { return tuiTick{} })
+}
+
+type tui struct {
+	header  string
+	entries []CombinedLog
+	lastSeq uint64
+	shown   []int // positions in entries that pass the filter
+	sel     int   // selected position in shown
+	top     int   // first row of shown in the list
+	follow  bool  // keep the newest entry selected
+	scroll  int   // first line of the detail pane
+	filter  string
+	typing  bool // editing the filter
+	rows    int
+	cols    int
+	logs    tuiLog
+}
+
+// tuiLog keeps the last line logged while the TUI runs, for the footer.
+type tuiLog struct {
+	mu   sync.Mutex
+	line string
+}
+
+func (l *tuiLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	l.line = strings.TrimSpace(string(p))
+	l.mu.Unlock()
+	return len(p), nil
+}
+
+func (l *tuiLog) last() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.line
+}
+
+// add appends e to the list. Entries can arrive twice, from the backfill
+// and from cliChan, so they are kept in seq order without repeats.
+func (t *tui) add(e CombinedLog) {
+	if e.Seq != 0 && e.Seq <= t.lastSeq {
+		return
+	}
+	t.lastSeq = max(t.lastSeq, e.Seq)
+	t.entries = append(t.entries, e)
+	if len(t.entries) > tuiScrollback {
+		t.entries = t.entries[len(t.entries)-tuiScrollback:]
+		t.refilter()
+		return
+	}
+	if t.matches(e) {
+		t.shown = append(t.shown, len(t.entries)-1)
+		if t.follow {
+			t.selectRow(len(t.shown) - 1)
+		}
+	}
+}
+
+// matches is the / filter: a case-insensitive substring of the method,
+// path, status, -proxy name or tag.
+func (t *tui) matches(e CombinedLog) bool {
+	if t.filter == "" {
+		return true
+	}
+	line := strings.ToLower(fmt.Sprintf("%s %s %d %s %s", e.Method, e.Path, e.Status, e.Proxy, e.Tag))
+	return strings.Contains(line, strings.ToLower(t.filter))
+}
+
+// refilter rebuilds shown, keeping the selected entry selected when it
+// still passes.
+func (t *tui) refilter() {
+	var selected *CombinedLog
+	if e, ok := t.selected(); ok {
+		selected = &e
+	}
+	t.shown = t.shown[:0]
+	row := -1
+	for i, e := range t.entries {
+		if !t.matches(e) {
+			continue
+		}
+		if selected != nil && e.Seq == selected.Seq {
+			row = len(t.shown)
+		}
+		t.shown = append(t.shown, i)
+	}
+	if row < 0 || t.follow {
+		row = len(t.shown) - 1
+	}
+	t.selectRow(row)
+}
+
+func (t *tui) selected() (CombinedLog, bool) {
+	if t.sel < 0 || t.sel >= len(t.shown) {
+		return CombinedLog{}, false
+	}
+	return t.entries[t.shown[t.sel]], true
+}
+
+func (t *tui) selectRow(row int) {
+	row = max(0, min(row, len(t.shown)-1))
+	if row != t.sel {
+		t.scroll = 0
+	}
+	t.sel = row
+	t.follow = row >= len(t.shown)-1
+}
+
+// listRows is the height of the request list; the detail pane gets the
+// rest below it.
+func (t *tui) listRows() int {
+	return max(3, (t.rows-4)*2/5)
+}
+
+func (t *tui) detailRows() int {
+	return max(1, t.rows-4-t.listRows())
+}
+
+func (t *tui) Init() tea.Cmd {
+	return tea.Batch(waitEntry, tickTUI())
+}
+
+func (t *tui) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		t.rows, t.cols = msg.Height, msg.Width
+	case tea.KeyMsg:
+		if !t.key(msg) {
+			return t, tea.Quit
+		}
+	case tuiEntry:
+		t.add(CombinedLog(msg))
+		return t, waitEntry
+	case tuiTick:
+		return t, tickTUI()
+	}
+	return t, nil
+}
+
+// key handles a key press. It returns false to quit.
+func (t *tui) key(k tea.KeyMsg) bool {
+	if k.Type == tea.KeyCtrlC { // raw mode delivers it as a key, not SIGINT
+		return false
+	}
+	if k.Type == tea.KeyRunes && len(k.Runes) > 1 { // typed faster than read, or pasted
+		for _, r := range k.Runes {
+			if !t.key(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}}) {
+				return false
+			}
+		}
+		return true
+	}
+	if t.typing {
+		switch k.Type {
+		case tea.KeyEnter:
+			t.typing = false
+		case tea.KeyEsc:
+			t.typing, t.filter = false, ""
+			t.refilter()
+		case tea.KeyBackspace:
+			if t.filter != "" {
+				_, n := utf8.DecodeLastRuneInString(t.filter)
+				t.filter = t.filter[:len(t.filter)-n]
+				t.refilter()
+			}
+		case tea.KeyRunes, tea.KeySpace:
+			t.filter += string(k.Runes)
+			t.refilter()
+		}
+		return true
+	}
+	switch k.String() {
+	case "q":
+		return false
+	case "/":
+		t.typing = true
+	case "p":
+		setCapturePaused(!capturePaused.Load())
+	case "esc":
+		if t.filter != "" {
+			t.filter = ""
+			t.refilter()
+		}
+	case "up", "k":
+		t.selectRow(t.sel - 1)
+	case "down", "j":
+		t.selectRow(t.sel + 1)
+	case "home", "g":
+		t.selectRow(0)
+	case "end", "G":
+		t.selectRow(len(t.shown) - 1)
+	case "pgup":
+		t.scroll = max(0, t.scroll-t.detailRows())
+	case "pgdown", " ":
+		t.scroll += t.detailRows()
+	}
+	return true
+}
+
+// View draws the screen, one line per terminal row. Bubble Tea cuts lines
+// longer than the terminal is wide.
+func (t *tui) View() string {
+	lines := make([]string, t.rows)
+	line := func(row int, s string) {
+		if row >= 1 && row <= len(lines) {
+			lines[row-1] = s + "\033[0m"
+		}
+	}
+	line(1, statusLine())
+	line(2, t.header)
+
+	rows := t.listRows()
+	if t.sel < t.top {
+		t.top = t.sel
+	} else if t.sel >= t.top+rows {
+		t.top = t.sel - rows + 1
+	}
+	t.top = max(0, min(t.top, len(t.shown)-rows))
+	for i := range rows {
+		row, s := t.top+i, ""
+		if row < len(t.shown) {
+			marker := "  "
+			if row == t.sel {
+				marker = colorize("1", "> ")
+			}
+			s = marker + cliLine(t.entries[t.shown[row]])
+		} else if row == 0 {
+			s = "  Waiting for requests..."
+			if t.filter != "" {
+				s = "  No requests match the filter."
+			}
+		}
+		line(3+i, s)
+	}
+
+	detailTop := 3 + rows
+	title := "─ Request "
+	if n := len(t.shown); n > 0 {
+		title += fmt.Sprintf("%d of %d ", t.sel+1, n)
+	}
+	line(detailTop, colorize("2", title+strings.Repeat("─", max(0, t.cols-len([]rune(title))))))
+	var detail []string
+	if e, ok := t.selected(); ok {
+		detail = entryDetail(e)
+	}
+	height := t.detailRows()
+	t.scroll = max(0, min(t.scroll, len(detail)-height))
+	for i := range height {
+		s := ""
+		if t.scroll+i < len(detail) {
+			s = detail[t.scroll+i]
+		}
+		line(detailTop+1+i, s)
+	}
+
+	footer := colorize("2", "↑/↓ select  PgUp/PgDn scroll detail  / filter  p pause capture  q quit")
+	if t.typing {
+		footer = "/" + t.filter + "█"
+	} else if t.filter != "" {
+		footer = colorize("33", "filter: "+t.filter) + colorize("2", "  (Esc clears)  ") + footer
+	}
+	if l := t.logs.last(); l != "" && !t.typing {
+		footer += colorize("2", "  | "+l)
+	}
+	line(t.rows, footer)
+	return strings.Join(lines, "\n")
+}
+
+// entryDetail is what the detail pane shows for e: its request and
+// response, headers as captured, JSON bodies indented.
+func entryDetail(e CombinedLog) []string {
+	var lines []string
+	add := func(s string) {
+		s = strings.ReplaceAll(s, "\t", "    ")
+		for l := range strings.SplitSeq(strings.TrimRight(s, "\r\n"), "\n") {
+			lines = append(lines, strings.TrimRight(l, "\r"))
+		}
+	}
+	path := e.Path
+	if e.QueryString != "" {
+		path += "?" + e.QueryString
+	}
+	add(fmt.Sprintf("%s %s  %s  %s  %s", colorize(methodColor(e.Method), e.Method), path,
+		colorize(statusColor(e.Status), statusLabel(e.Status)), e.Latency, e.TimeISO))
+	if e.Note != "" {
+		add(colorize("33", "Note: "+e.Note))
+	}
+	if e.ClientError != "" {
+		add(colorize("31", "Client error: "+e.ClientError))
+	}
+	section := func(title, headers, body, encoding string, trailers http.Header) {
+		add("")
+		add(colorize("1", title))
+		if headers != "" {
+			add(headers)
+		}
+		switch {
+		case body == "":
+			add(colorize("2", "(no body)"))
+		case encoding == "base64":
+			add(colorize("2", fmt.Sprintf("(binary body, %d bytes base64-encoded)", len(body))))
+		default:
+			var out bytes.Buffer
+			if json.Indent(&out, []byte(body), "", "  ") == nil {
+				body = out.String()
+			}
+			add(body)
+		}
+		for _, name := range slices.Sorted(maps.Keys(trailers)) {
+			for _, v := range trailers[name] {
+				add(colorize("2", "Trailer ") + name + ": " + v)
+			}
+		}
+	}
+	section("Request", e.ReqHeaders, e.ReqBody, e.ReqBodyEncoding, nil)
+	section("Response", e.RespHeaders, e.RespBody, e.RespBodyEncoding, e.RespTrailers)
+	return lines
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// uiRoot is -ui-dir: a directory to serve the inspector from instead of the
+// embedded index.html. os.Root keeps every lookup inside it, symlinks
+// included, so /inspect/../../etc/passwd has nowhere to go.
+var uiRoot *os.Root
+
+func setUIDir(dir string) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	uiRoot = root
+	return nil
+}
+
+// handleInspect serves the inspector page: -ui-dir's index.html when there
+// is one, checked on each request so a fresh build shows up on reload, and
+// the embedded page otherwise.
+func handleInspect(w http.ResponseWriter, r *http.Request) {
+	if uiRoot != nil {
+		if _, err := fs.Stat(uiRoot.FS(), "index.html"); err == nil {
+			w.Header().Set("Cache-Control", "no-cache")
+			http.ServeFileFS(w, r, uiRoot.FS(), "index.html")
+			return
+		}
+	}
+	data, _ := staticFiles.ReadFile("index.html")
+	w.Header().Set("Content-Type", "text/html")
+	w.Write(data)
+}
+
+// handleUIAsset serves GET /inspect/{file...} from -ui-dir, with content
+// types from the file extension. Responses carry Last-Modified and
+// no-cache, so browsers revalidate rather than keep a stale build.
+// Directories are never listed.
+func handleUIAsset(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("file")
+	if name == "" || name == "index.html" {
+		handleInspect(w, r)
+		return
+	}
+	if strings.HasSuffix(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := uiRoot.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil || st.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, st.Name(), st.ModTime(), f)
+}
+
+// isUIAsset reports whether r is for a -ui-dir asset. Assets are served
+// without a token, like the files of any static site: a browser doesn't
+// add ?token= to the scripts and stylesheets a page loads.
+func isUIAsset(mux *http.ServeMux, r *http.Request) bool {
+	if uiRoot == nil {
+		return false
+	}
+	_, pattern := mux.Handler(r)
+	name := strings.TrimPrefix(r.URL.Path, "/inspect/")
+	return pattern == "GET /inspect/{file...}" && name != "" && name != "index.html"
+}
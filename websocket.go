@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait  = 5 * time.Second // per message write
+	wsPongWait   = 6 * time.Second // a client silent this long is dropped
+	wsPingPeriod = 2 * time.Second
+	wsSendBuffer = 256 // messages queued per client before the oldest are dropped
+
+	// wsCompressMin is the smallest message worth compressing; below it
+	// deflate costs more CPU than it saves bandwidth.
+	wsCompressMin = 512
+)
+
+// noWSCompression (-no-ws-compression) turns off permessage-deflate, for
+// CPU-constrained machines. Otherwise it is used with every client that
+// offers it.
+var noWSCompression bool
+
+// wireCounter hands the upgrader a connection that counts the bytes
+// written to it, so /api/clients can show what a client costs after
+// compression.
+type wireCounter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (w wireCounter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return countingConn{conn, w.n}, brw, nil
+}
+
+type countingConn struct {
+	net.Conn
+	n *atomic.Int64
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// wsClient is one inspector tab. Broadcasts only ever queue onto send; the
+// client's own writer goroutine does the (possibly slow) network writes.
+type wsClient struct {
+	clientStats
+	conn    *websocket.Conn
+	send    chan []byte
+	dropped atomic.Int64 // messages discarded since the last one delivered
+	filter  *wsFilter    // set by a subscribe message; guarded by clientsMu
+	msgpack bool         // ?encoding=msgpack: binary MessagePack messages
+	summary bool         // ?mode=summary: entries as entrySummary
+	legacy  bool         // ?legacy=true: bare messages, without the envelope
+
+	compressed bool        // permessage-deflate was negotiated
+	share      *shareGrant // the share link it connected with, if any
+}
+
+// sees reports whether c receives e: within its share, then its filter.
+func (c *wsClient) sees(e *CombinedLog) bool {
+	return c.share.filter().matches(e) && c.filter.matches(e)
+}
+
+// marshal encodes v in the client's encoding, inside the envelope unless
+// the client asked for the legacy format.
+func (c *wsClient) marshal(v any) ([]byte, error) {
+	if !c.legacy {
+		v = envelope(v)
+	}
+	if c.msgpack {
+		return marshalMsgpack(v)
+	}
+	return json.Marshal(v)
+}
+
+// wsEnvelopeVersion is the "v" of every websocket message. Bump it if
+// the envelope or a message's data changes incompatibly.
+const wsEnvelopeVersion = 1
+
+// wsEnvelope wraps every server→client message: {"v":1,"type":...,"data":...}.
+// Captured entries have type "request"; events keep their own type name
+// and the rest of their fields become data.
+type wsEnvelope struct {
+	V    int    `json:"v"`
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+func envelope(v any) wsEnvelope {
+	if ev, ok := v.(map[string]any); ok {
+		data := maps.Clone(ev)
+		delete(data, "type")
+		typ, _ := ev["type"].(string)
+		return wsEnvelope{V: wsEnvelopeVersion, Type: typ, Data: data}
+	}
+	return wsEnvelope{V: wsEnvelopeVersion, Type: "request", Data: v}
+}
+
+// wsMessage is one broadcast, encoded at most once per encoding and mode
+// no matter how many clients receive it. Used under clientsMu.
+type wsMessage struct {
+	v   any
+	out map[[3]bool][]byte // by {msgpack, summary, legacy}
+}
+
+// encodedFor returns m encoded for c, or nil if it can't be encoded.
+func (m *wsMessage) encodedFor(c *wsClient) []byte {
+	k := [3]bool{c.msgpack, c.summary, c.legacy}
+	if out, ok := m.out[k]; ok {
+		return out
+	}
+	v := m.v
+	if e, ok := v.(CombinedLog); ok && c.summary {
+		v = summarize(e)
+	}
+	out, _ := c.marshal(v)
+	if m.out == nil {
+		m.out = map[[3]bool][]byte{}
+	}
+	m.out[k] = out
+	return out
+}
+
+// wsFilter limits which captured entries a client receives. Zero fields
+// match everything. Typed events (playback, capture...) are always sent.
+type wsFilter struct {
+	Method    []string `json:"method"`
+	PathRegex string   `json:"path_regex"`
+	MinStatus int      `json:"min_status"`
+	Proxy     []string `json:"proxy"` // -proxy names
+	Tag       []string `json:"tag"`   // X-ProxyEye-Tag values
+
+	pathRe *regexp.Regexp
+}
+
+// compile validates f and prepares it for matching. An empty filter
+// compiles to nil, which matches everything.
+func (f *wsFilter) compile() (*wsFilter, error) {
+	if f == nil {
+		return nil, nil
+	}
+	for i, m := range f.Method {
+		f.Method[i] = strings.ToUpper(m)
+	}
+	if f.PathRegex != "" {
+		re, err := regexp.Compile(f.PathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path_regex: %v", err)
+		}
+		f.pathRe = re
+	}
+	if len(f.Method) == 0 && f.pathRe == nil && f.MinStatus == 0 && len(f.Proxy) == 0 && len(f.Tag) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func (f *wsFilter) matches(e *CombinedLog) bool {
+	if f == nil {
+		return true
+	}
+	return (len(f.Method) == 0 || slices.Contains(f.Method, e.Method)) &&
+		(len(f.Proxy) == 0 || slices.Contains(f.Proxy, e.Proxy)) &&
+		(len(f.Tag) == 0 || slices.Contains(f.Tag, e.Tag)) &&
+		(f.pathRe == nil || f.pathRe.MatchString(e.Path)) &&
+		e.Status >= f.MinStatus
+}
+
+// entrySeq numbers captured entries as they are published.
+var entrySeq uint64
+
+// serverStarted tells clients which run of ProxyEye they are connected to,
+// so a reconnect can be told apart from a restart.
+var serverStarted = time.Now()
+
+// serverInfo is the {"type":"server_info"} hello each client gets before
+// anything else. Called under clientsMu.
+func serverInfo() map[string]any {
+	return map[string]any{
+		"type":           "server_info",
+		"version":        buildVersion().Version,
+		"started":        serverStarted.Format(time.RFC3339),
+		"target":         fixedConfig["target"],
+		"seq":            entrySeq,
+		"capture_paused": capturePaused.Load(),
+		"read_only":      readOnly,
+		"token_required": authToken != "",
+	}
+}
+
+// handleWS registers an inspector client. A read pump answers control
+// frames and notices closes; pings catch clients that vanished silently.
+//
+// Every client first gets a server_info hello (version, target, capture
+// state...). With ?backfill=N it then gets the last N history entries as one
+// {"v":1,"type":"backfill","data":{"entries":[...]}} message; live entries
+// follow as {"v":1,"type":"request","data":...}.
+// A reconnecting client passes ?since=SEQ (the last seq it saw) to get the
+// entries it missed instead; "gap" is set if some already left history.
+//
+// ?encoding=msgpack switches the client to binary messages: a version byte
+// (msgpackVersion) followed by the MessagePack form of the same objects.
+// Client→server messages stay JSON text.
+//
+// ?mode=summary sends entries (live and backfilled) as entrySummary, with
+// previews instead of bodies; the client fetches GET /history/{seq} for
+// the ones it opens.
+//
+// Every message is wrapped in a wsEnvelope. ?legacy=true sends them bare,
+// as before the envelope, for scripts that haven't moved yet; it goes away
+// in the next release.
+func handleWS(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch q.Get("encoding") {
+	case "", "json", "msgpack":
+	default:
+		http.Error(w, "encoding must be json or msgpack", http.StatusBadRequest)
+		return
+	}
+	switch q.Get("mode") {
+	case "", "full", "summary":
+	default:
+		http.Error(w, "mode must be full or summary", http.StatusBadRequest)
+		return
+	}
+	backfill, since := -1, uint64(0)
+	if v := q.Get("backfill"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "backfill must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		backfill = n
+	}
+	if v := q.Get("since"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "since must be a sequence number", http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+	c := &wsClient{clientStats: newClientStats(r), send: make(chan []byte, wsSendBuffer), msgpack: q.Get("encoding") == "msgpack", summary: q.Get("mode") == "summary", legacy: q.Get("legacy") == "true"}
+	ws, err := upgrader.Upgrade(wireCounter{w, &c.wire}, r, nil)
+	if err != nil {
+		return // Upgrade has already replied with an error
+	}
+	c.conn = ws
+	c.compressed = upgrader.EnableCompression && strings.Contains(r.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+	c.share = requestShare(r)
+	// Holding clientsMu keeps publishEntry out, so every entry is either in
+	// the backfill or sent live, never both.
+	clientsMu.Lock()
+	info := serverInfo()
+	if c.share != nil {
+		info["read_only"], info["share"] = true, c.share
+	}
+	if msg, err := c.marshal(info); err == nil {
+		c.enqueue(msg)
+	}
+	if q.Has("since") || backfill > 0 {
+		// A since beyond our last seq means ProxyEye restarted: resend all.
+		restarted := since > entrySeq
+		if restarted {
+			since = 0
+		}
+		historyMutex.Lock()
+		entries := []CombinedLog{}
+		for _, e := range history {
+			if e.Seq > since && c.share.filter().matches(&e) {
+				entries = append(entries, e)
+			}
+		}
+		historyMutex.Unlock()
+		gap := q.Has("since") && (restarted || uint64(len(entries)) < entrySeq-since)
+		if backfill >= 0 {
+			entries = entries[max(len(entries)-backfill, 0):]
+		}
+		ev := map[string]any{"type": "backfill", "entries": entries}
+		if c.summary {
+			ev["entries"] = summarizeAll(entries)
+		}
+		if gap {
+			ev["gap"] = true
+		}
+		if msg, err := c.marshal(ev); err == nil {
+			c.enqueue(msg)
+		}
+	}
+	clients[c] = true
+	clientsMu.Unlock()
+	go c.writePump()
+
+	ws.SetReadLimit(4096)
+	ws.SetReadDeadline(time.Now().Add(wsPongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			break
+		}
+		c.handleMessage(msg)
+	}
+	removeClient(c)
+}
+
+// handleMessage handles a client→server message. The only one is
+// {"type":"subscribe","filter":{...}}, which replaces the client's filter;
+// mistakes are reported back as {"type":"error"}.
+func (c *wsClient) handleMessage(data []byte) {
+	var msg struct {
+		Type   string    `json:"type"`
+		Filter *wsFilter `json:"filter"`
+	}
+	reply := func(v map[string]any) {
+		out, err := c.marshal(v)
+		if err != nil {
+			return
+		}
+		clientsMu.Lock()
+		c.enqueue(out)
+		clientsMu.Unlock()
+	}
+	fail := func(format string, args ...any) {
+		reply(map[string]any{"type": "error", "error": fmt.Sprintf(format, args...)})
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		fail("invalid JSON: %v", err)
+		return
+	}
+	if msg.Type != "subscribe" {
+		fail("unknown message type %q", msg.Type)
+		return
+	}
+	f, err := msg.Filter.compile()
+	if err != nil {
+		fail("%v", err)
+		return
+	}
+	clientsMu.Lock()
+	c.filter = f
+	clientsMu.Unlock()
+	reply(map[string]any{"type": "subscribed", "filter": f})
+}
+
+// writePump delivers queued messages and pings. Before a message that
+// follows dropped ones, the client is told how many it missed.
+func (c *wsClient) writePump() {
+	t := time.NewTicker(wsPingPeriod)
+	defer t.Stop()
+	defer c.conn.Close()
+	frame := websocket.TextMessage
+	if c.msgpack {
+		frame = websocket.BinaryMessage
+	}
+	write := func(msg []byte) error {
+		c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		c.conn.EnableWriteCompression(len(msg) >= wsCompressMin)
+		c.bytes.Add(int64(len(msg)))
+		return c.conn.WriteMessage(frame, msg)
+	}
+	for {
+		select {
+		case msg, ok := <-c.send:
+			if !ok {
+				return // removed
+			}
+			if n := c.dropped.Swap(0); n > 0 {
+				note, _ := c.marshal(map[string]any{"type": "dropped", "count": n})
+				if write(note) != nil {
+					removeClient(c)
+					return
+				}
+			}
+			if write(msg) != nil {
+				removeClient(c)
+				return
+			}
+			c.sent.Add(1)
+		case <-t.C:
+			if c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)) != nil {
+				removeClient(c)
+				return
+			}
+		}
+	}
+}
+
+// enqueue queues msg without blocking, discarding the oldest queued
+// message while the buffer is full. Callers hold clientsMu.
+func (c *wsClient) enqueue(msg []byte) {
+	for {
+		select {
+		case c.send <- msg:
+			return
+		default:
+		}
+		select {
+		case <-c.send:
+			c.dropped.Add(1)
+			c.lost.Add(1)
+		default:
+		}
+	}
+}
+
+// closeClients sends every client a close frame, e.g. 1001 (going away) on
+// shutdown, so tabs can tell why the socket closed.
+func closeClients(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	deadline := time.Now().Add(time.Second) // shared: don't let one stuck tab delay exit
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for c := range clients {
+		c.conn.WriteControl(websocket.CloseMessage, msg, deadline)
+	}
+}
+
+func removeClient(c *wsClient) {
+	clientsMu.Lock()
+	if clients[c] {
+		delete(clients, c)
+		close(c.send) // stops the writer, which closes the connection
+	}
+	clientsMu.Unlock()
+	c.conn.Close()
+}
+
+// writeClients queues v for every inspector client. It never waits on the
+// network, so a stalled tab can't hold up capture or the other tabs.
+func writeClients(v any) {
+	msg := &wsMessage{v: v}
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for c := range clients {
+		if out := msg.encodedFor(c); out != nil {
+			c.enqueue(out)
+		}
+	}
+}
+
+// publishEntry stores a captured entry in history and queues it for every
+// client, as one step with respect to handleWS's backfill. It returns the
+// entry with its seq set.
+func publishEntry(entry CombinedLog) CombinedLog {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	entrySeq++
+	entry.Seq = entrySeq
+	if ce, ok := lateClientErrors[entry.RequestID]; ok && entry.RequestID != 0 {
+		entry.ClientError = ce
+		delete(lateClientErrors, entry.RequestID)
+	}
+	evicted := saveToHistory(entry)
+	msg := &wsMessage{v: entry}
+	for c := range clients {
+		if c.sees(&entry) {
+			if out := msg.encodedFor(c); out != nil {
+				c.enqueue(out)
+			}
+		}
+	}
+	publishToStreams(&entry)
+	wakePolls()
+	if len(evicted) > 0 {
+		ev := &wsMessage{v: map[string]any{"type": "entry_evicted", "seqs": evicted}}
+		for c := range clients {
+			if out := ev.encodedFor(c); out != nil {
+				c.enqueue(out)
+			}
+		}
+	}
+	return entry
+}
+
+func clientCount() int {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	return len(clients)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// runBroadcaster does handleBroadcasts' job until the test ends.
+func runBroadcaster(t *testing.T) {
+	t.Helper()
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case msg := <-broadcast:
+				deliverEntry(msg)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+}
+
+// newTestProxy serves the capturing proxy in front of target.
+func newTestProxy(t *testing.T, target string) *httptest.Server {
+	t.Helper()
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	proxy.Director = proxyDirector(proxy.Director)
+	proxy.ModifyResponse, proxy.ErrorHandler = modifyResponse, proxyErrorHandler
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveProxied(w, r, proxy)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestStalledClientDoesNotSlowProxy(t *testing.T) {
+	maxBody.Store(1 << 20)
+	noCLI = true
+	t.Cleanup(func() {
+		maxBody.Store(0)
+		noCLI = false
+	})
+	runBroadcaster(t)
+
+	body := strings.Repeat("x", 64<<10)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer backend.Close()
+	front := newTestProxy(t, backend.URL)
+	ui := httptest.NewServer(http.HandlerFunc(handleWS))
+	defer ui.Close()
+
+	get := func() time.Duration {
+		start := time.Now()
+		resp, err := http.Get(front.URL + "/data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return time.Since(start)
+	}
+
+	// A client with a small receive buffer that never reads: its socket
+	// fills after a few entries, and from then on every write to it blocks.
+	dialer := websocket.Dialer{NetDial: func(network, addr string) (net.Conn, error) {
+		conn, err := net.Dial(network, addr)
+		if err == nil {
+			conn.(*net.TCPConn).SetReadBuffer(4096)
+		}
+		return conn, err
+	}}
+	ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(ui.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	var stalled *wsClient
+	for deadline := time.Now().Add(time.Second); stalled == nil && time.Now().Before(deadline); {
+		clientsMu.Lock()
+		for c := range clients {
+			stalled = c
+		}
+		clientsMu.Unlock()
+	}
+	if stalled == nil {
+		t.Fatal("websocket client never registered")
+	}
+
+	// More entries than the client's queue holds. A write blocked on the
+	// client would hold a request for up to wsWriteWait.
+	var worst time.Duration
+	for range wsSendBuffer * 2 {
+		worst = max(worst, get())
+	}
+	if worst > 500*time.Millisecond {
+		t.Errorf("slowest proxied request took %v with a stalled client", worst)
+	}
+	if stalled.lost.Load() == 0 {
+		t.Fatal("the client never fell behind; the test proved nothing")
+	}
+}
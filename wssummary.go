@@ -0,0 +1,119 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+)
+
+// summaryPreview is how much of each body a summary carries, in runes.
+const summaryPreview = 120
+
+// entrySummary is an entry as /ws?mode=summary sends it: what the list view
+// needs, with short previews instead of bodies. The full entry is at
+// GET /history/{seq}.
+type entrySummary struct {
+	Seq             uint64 `json:"seq"`
+	Method          string `json:"method"`
+	Path            string `json:"path"`
+	QueryString     string `json:"query_string"`
+	Status          int    `json:"status"`
+	Latency         string `json:"latency"`
+	Time            string `json:"time"`
+	TimeISO         string `json:"time_iso"`
+	ReqSize         int    `json:"req_size"`  // captured body bytes
+	RespSize        int    `json:"resp_size"` // (up to -max-body)
+	ReqContentType  string `json:"req_content_type,omitempty"`
+	RespContentType string `json:"resp_content_type,omitempty"`
+	ReqPreview      string `json:"req_preview,omitempty"` // text bodies only
+	RespPreview     string `json:"resp_preview,omitempty"`
+	ReqTruncated    bool   `json:"req_body_truncated,omitempty"`
+	RespTruncated   bool   `json:"resp_body_truncated,omitempty"`
+	Throttle        string `json:"throttle,omitempty"`
+	Proxy           string `json:"proxy,omitempty"`
+	Source          string `json:"source,omitempty"`
+	Tag             string `json:"tag,omitempty"`
+	Note            string `json:"note,omitempty"`
+	RequestID       uint64 `json:"request_id,omitempty"`
+}
+
+func summarize(e CombinedLog) entrySummary {
+	req, resp := decodeBody(e.ReqBody, e.ReqBodyEncoding), decodeBody(e.RespBody, e.RespBodyEncoding)
+	s := entrySummary{
+		Seq: e.Seq, Method: e.Method, Path: e.Path, QueryString: e.QueryString,
+		Status: e.Status, Latency: e.Latency, Time: e.Time, TimeISO: e.TimeISO,
+		ReqSize: len(req), RespSize: len(resp),
+		ReqContentType:  parseHeaderDump(e.ReqHeaders).Get("Content-Type"),
+		RespContentType: parseHeaderDump(e.RespHeaders).Get("Content-Type"),
+		ReqTruncated:    e.ReqTruncated, RespTruncated: e.RespTruncated,
+		Throttle: e.Throttle, Proxy: e.Proxy, Source: e.Source, Tag: e.Tag, Note: e.Note,
+		RequestID: e.RequestID,
+	}
+	if e.ReqBodyEncoding == "" {
+		s.ReqPreview = preview(e.ReqBody)
+	}
+	if e.RespBodyEncoding == "" {
+		s.RespPreview = preview(e.RespBody)
+	}
+	return s
+}
+
+func preview(body string) string {
+	if utf8.RuneCountInString(body) <= summaryPreview {
+		return body
+	}
+	return string([]rune(body)[:summaryPreview]) + "…"
+}
+
+func summarizeAll(entries []CombinedLog) []entrySummary {
+	out := make([]entrySummary, len(entries))
+	for i, e := range entries {
+		out[i] = summarize(e)
+	}
+	return out
+}
+
+// historyIndex finds where the entry numbered seq is in history, which is
+// in seq order. Callers hold historyMutex.
+func historyIndex(seq uint64) (int, bool) {
+	return slices.BinarySearchFunc(history, seq, func(e CombinedLog, seq uint64) int {
+		return cmp.Compare(e.Seq, seq)
+	})
+}
+
+// historyBySeq finds the entry numbered seq. Unlike a position in history,
+// a seq keeps pointing at the same entry as older ones are evicted.
+func historyBySeq(seq uint64) (CombinedLog, bool) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	i, ok := historyIndex(seq)
+	if !ok {
+		return CombinedLog{}, false
+	}
+	return history[i], true
+}
+
+// pathSeq parses the {seq} of routes such as /history/{seq}/body.
+func pathSeq(r *http.Request) (uint64, error) {
+	return strconv.ParseUint(r.PathValue("seq"), 10, 64)
+}
+
+// handleHistoryEntry serves GET /history/{seq}: one full entry, as
+// referenced by a summary. 404 once it has left history.
+func handleHistoryEntry(w http.ResponseWriter, r *http.Request) {
+	seq, err := pathSeq(r)
+	if err != nil {
+		http.Error(w, "invalid seq", http.StatusBadRequest)
+		return
+	}
+	e, ok := historyBySeq(seq)
+	if !ok || !requestShare(r).filter().matches(&e) {
+		http.Error(w, "no such history entry", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}